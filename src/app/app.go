@@ -1,10 +1,12 @@
 package app
 
 import (
+	"context"
 	"framework/cfgargs"
 	"framework/db"
 	"framework/logger"
 	"github.com/gin-gonic/gin"
+	"logic/conf"
 	"logic/server"
 	"sync"
 )
@@ -15,8 +17,9 @@ var (
 )
 
 type App struct {
-	cfg *cfgargs.SrvConfig
-	srv *server.Server
+	cfg      *cfgargs.SrvConfig
+	logicCfg *conf.LogicConfig
+	srv      *server.Server
 }
 
 func GetApp() *App {
@@ -27,7 +30,7 @@ func GetApp() *App {
 	return app
 }
 
-func (a *App) Init(cfg *cfgargs.SrvConfig) {
+func (a *App) Init(cfg *cfgargs.SrvConfig, logicCfg *conf.LogicConfig) {
 	gin.DefaultWriter = logger.MultiWriter(logger.DefLogger().GetLogWriters()...)
 	db.InitRedisClient(cfg)
 	err := db.InitMongoClient(cfg)
@@ -36,7 +39,16 @@ func (a *App) Init(cfg *cfgargs.SrvConfig) {
 		return
 	}
 
+	a.cfg = cfg
+	a.logicCfg = logicCfg
 	a.srv = server.NewServer()
-	a.srv.Init(cfg)
+	a.srv.Init(cfg, logicCfg)
 	a.srv.Run()
 }
+
+func (a *App) Shutdown(ctx context.Context) error {
+	if a.srv == nil {
+		return nil
+	}
+	return a.srv.Shutdown(ctx)
+}
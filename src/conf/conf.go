@@ -0,0 +1,236 @@
+// Package conf
+// @Title  conf.go
+// @Description  logic 服务私有配置, 与 cfgargs 共用同一份配置文件的 logic 节点
+// cfgargs 属于 gate/broker 共用的 framework 模块, 只有 logic 使用的配置放在这里, 避免改动共享模块
+// @Author  peanut996
+package conf
+
+import (
+	"flag"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+)
+
+const (
+	// DeliveryModeAsync 入队与推送并行, 延迟低但推送的消息可能最终写库失败
+	DeliveryModeAsync = "async"
+	// DeliveryModeOrdered 先写库再推送, 直接写库不经过消息队列, 队列的攒批, Ack 与关闭时排空只对 async 生效
+	DeliveryModeOrdered = "ordered"
+)
+
+const (
+	QueueModeMemory = "memory"
+	QueueModeWAL    = "wal"
+	QueueModeRedis  = "redis"
+)
+
+type QueueConfig struct {
+	// Mode memory|wal|redis
+	Mode string `yaml:"mode"`
+	// Capacity 内存队列容量, 超出后 Produce 返回错误而不是阻塞
+	Capacity int `yaml:"capacity"`
+	// Dir wal 文件目录
+	Dir string `yaml:"dir"`
+	// Sync 每次写入 wal 后是否 fsync
+	Sync bool `yaml:"sync"`
+	// Stream redis stream 名称
+	Stream string `yaml:"stream"`
+	// Group redis 消费组
+	Group string `yaml:"group"`
+	// Consumer redis 消费者名称, 同一组内需唯一, 为空时使用主机名
+	Consumer string `yaml:"consumer"`
+}
+
+type ConsumerConfig struct {
+	// BatchSize 每批最多写入的消息数
+	BatchSize int `yaml:"batchSize"`
+	// FlushInterval 未攒满一批时的最长等待时间, 单位毫秒
+	FlushInterval int `yaml:"flushInterval"`
+}
+
+type ChatConfig struct {
+	// DeliveryMode async|ordered
+	DeliveryMode string `yaml:"deliveryMode"`
+	// DedupeWindow 按 clientMsgID 去重的时间窗口, 单位秒
+	DedupeWindow int `yaml:"dedupeWindow"`
+	// RecallWindow 发送后可以撤回的时间, 单位秒
+	RecallWindow int `yaml:"recallWindow"`
+	// QuoteLength 回复时引用原消息的最大字符数
+	QuoteLength int `yaml:"quoteLength"`
+	// MentionLimit 每个用户在每个群保留的未读提及数
+	MentionLimit int `yaml:"mentionLimit"`
+}
+
+type AuthConfig struct {
+	// Enabled 是否对 Auth 以外的事件做调用者鉴权
+	Enabled bool `yaml:"enabled"`
+	// SessionTTL token 到 UID 缓存的有效期, 单位秒, 0 不缓存
+	// token 被吊销后最多还能使用这么久, 不应超过 token 本身的有效期
+	SessionTTL int `yaml:"sessionTTL"`
+	// Admins 可以调用管理接口的 UID
+	Admins []string `yaml:"admins"`
+}
+
+type TypingConfig struct {
+	// Interval 同一用户在同一房间转发输入状态的最小间隔, 单位毫秒
+	Interval int `yaml:"interval"`
+	// Expire 客户端未收到新状态时自动清除输入提示的时间, 单位毫秒
+	Expire int `yaml:"expire"`
+}
+
+type PresenceConfig struct {
+	// Nodes gate 节点名到 invoke 地址, 未配置的节点与没有连接记录的用户走默认 broker
+	Nodes map[string]string `yaml:"nodes"`
+	// TTL 用户连接记录的过期时间, 每次 connect 刷新, 单位秒
+	TTL int `yaml:"ttl"`
+	// Timeout 调用 gate 节点的超时时间, 单位毫秒
+	Timeout int `yaml:"timeout"`
+	// GateSecret 非空时 gate 须在 X-Gate-Secret 中携带, 否则请求中的节点名不被采用
+	GateSecret string `yaml:"gateSecret"`
+}
+
+type InvokeConfig struct {
+	// MaxAttempts 每次推送最多尝试的次数, 包含第一次
+	MaxAttempts int `yaml:"maxAttempts"`
+	// BaseDelay 第一次重试前的等待时间, 之后每次翻倍, 单位毫秒
+	BaseDelay int `yaml:"baseDelay"`
+	// MaxDelay 重试等待时间上限, 单位毫秒
+	MaxDelay int `yaml:"maxDelay"`
+	// Jitter 等待时间的随机浮动比例, 0 到 1
+	Jitter float64 `yaml:"jitter"`
+	// BreakerThreshold 同一 gate 连续失败多少次后熔断, 0 不熔断
+	BreakerThreshold int `yaml:"breakerThreshold"`
+	// BreakerCooldown 熔断后多久放行探测请求, 单位毫秒
+	BreakerCooldown int `yaml:"breakerCooldown"`
+}
+
+type SyncConfig struct {
+	// MaxChanges 每个用户保留的增量变更条数, 落后更多时返回全量数据
+	MaxChanges int `yaml:"maxChanges"`
+	// TTL 变更日志的保留时间, 单位秒
+	TTL int `yaml:"ttl"`
+	// LegacyPush 同时推送全量 load 数据, 兼容不支持增量同步的旧客户端
+	LegacyPush bool `yaml:"legacyPush"`
+}
+
+type LoadPushConfig struct {
+	// Delay 合并同一用户 load 推送的等待时间, 单位毫秒
+	Delay int `yaml:"delay"`
+	// Workers 并发执行 load 推送的协程数
+	Workers int `yaml:"workers"`
+	// QueueSize 等待执行的推送队列长度
+	QueueSize int `yaml:"queueSize"`
+}
+
+type InboxConfig struct {
+	// Enabled 是否记录待确认消息并在重连时补发, 客户端需要支持 ack 后再开启
+	Enabled bool `yaml:"enabled"`
+	// MaxSize 每个用户最多保留的待确认消息数, 超出丢弃最旧的
+	MaxSize int `yaml:"maxSize"`
+	// TTL 收件箱保留时间, 单位秒
+	TTL int `yaml:"ttl"`
+	// FlushLimit 每次重连补发的最大条数
+	FlushLimit int `yaml:"flushLimit"`
+}
+
+type LogicConfig struct {
+	LoadPush LoadPushConfig `yaml:"loadPush"`
+	Sync     SyncConfig     `yaml:"sync"`
+	Auth     AuthConfig     `yaml:"auth"`
+	Chat     ChatConfig     `yaml:"chat"`
+	Inbox    InboxConfig    `yaml:"inbox"`
+	Invoke   InvokeConfig   `yaml:"invoke"`
+	Presence PresenceConfig `yaml:"presence"`
+	Typing   TypingConfig   `yaml:"typing"`
+	Queue    QueueConfig    `yaml:"queue"`
+	Consumer ConsumerConfig `yaml:"consumer"`
+	// ShutdownTimeout 优雅退出的最长等待时间, 单位秒
+	ShutdownTimeout int `yaml:"shutdownTimeout"`
+}
+
+func DefaultLogicConfig() *LogicConfig {
+	return &LogicConfig{
+		LoadPush: LoadPushConfig{
+			Delay:     200,
+			Workers:   16,
+			QueueSize: 1024,
+		},
+		Sync: SyncConfig{
+			MaxChanges: 500,
+			TTL:        7 * 24 * 3600,
+		},
+		Auth: AuthConfig{
+			Enabled:    true,
+			SessionTTL: 300,
+		},
+		Chat: ChatConfig{
+			DeliveryMode: DeliveryModeOrdered,
+			DedupeWindow: 300,
+			RecallWindow: 120,
+			QuoteLength:  100,
+			MentionLimit: 99,
+		},
+		Inbox: InboxConfig{
+			Enabled:    false,
+			MaxSize:    1000,
+			TTL:        7 * 24 * 3600,
+			FlushLimit: 200,
+		},
+		Invoke: InvokeConfig{
+			MaxAttempts:      5,
+			BaseDelay:        100,
+			MaxDelay:         5000,
+			Jitter:           0.2,
+			BreakerThreshold: 5,
+			BreakerCooldown:  10000,
+		},
+		Presence: PresenceConfig{
+			TTL:     24 * 3600,
+			Timeout: 3000,
+		},
+		Typing: TypingConfig{
+			Interval: 2000,
+			Expire:   6000,
+		},
+		Queue: QueueConfig{
+			Mode:     QueueModeMemory,
+			Capacity: 5000,
+			Dir:      "./data/queue",
+			Sync:     true,
+			Stream:   "logic:chat",
+			Group:    "logic",
+		},
+		Consumer: ConsumerConfig{
+			BatchSize:     100,
+			FlushInterval: 200,
+		},
+		ShutdownTimeout: 30,
+	}
+}
+
+// ConfigPath 返回启动参数 -c 指定的配置文件路径
+func ConfigPath() string {
+	if f := flag.Lookup("c"); f != nil {
+		return f.Value.String()
+	}
+	return ""
+}
+
+// InitLogicCfg 读取配置文件中的 logic 节点, 未配置的字段使用默认值
+func InitLogicCfg(path string) (*LogicConfig, error) {
+	cfg := DefaultLogicConfig()
+	if len(path) == 0 {
+		return cfg, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	wrapper := struct {
+		Logic *LogicConfig `yaml:"logic"`
+	}{cfg}
+	if err = yaml.Unmarshal(content, &wrapper); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,333 @@
+package dao
+
+import (
+	"errors"
+	"framework/api/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"regexp"
+	"time"
+)
+
+const CollectionChatMessageEdit = "chatMessageEdit"
+
+// ChatMessageEdit 消息被编辑前的内容
+type ChatMessageEdit struct {
+	MessageID string `json:"messageID" bson:"messageID"`
+	Content   string `json:"content" bson:"content"`
+	// EditTime 被新内容替换的时间, 毫秒时间戳
+	EditTime int64 `json:"editTime" bson:"editTime"`
+}
+
+// ChatMessage 在 framework 聊天消息的基础上附加服务端分配的消息 ID 与房间内序号
+type ChatMessage struct {
+	model.ChatMessage `bson:",inline"`
+	MessageID         string `json:"messageID" bson:"messageID"`
+	Seq               int64  `json:"seq" bson:"seq"`
+	// ClientMsgID 客户端生成的幂等键
+	ClientMsgID string `json:"clientMsgID,omitempty" bson:"clientMsgID,omitempty"`
+	// Recalled 发送者撤回后内容被清空
+	Recalled bool  `json:"recalled,omitempty" bson:"recalled,omitempty"`
+	EditTime int64 `json:"editTime,omitempty" bson:"editTime,omitempty"`
+	// DeletedFor 删除了这条消息的用户, 拉取时对这些用户隐藏
+	DeletedFor []string `json:"-" bson:"deletedFor,omitempty"`
+	// ReplyTo 回复的消息, Quote 为其发送时的摘要
+	ReplyTo string `json:"replyTo,omitempty" bson:"replyTo,omitempty"`
+	Quote   *Quote `json:"quote,omitempty" bson:"quote,omitempty"`
+	// ThreadRoot 所属话题的首条消息
+	ThreadRoot string `json:"threadRoot,omitempty" bson:"threadRoot,omitempty"`
+	// Mentions 被提及的群成员, MentionAll 为 @all
+	Mentions   []string `json:"mentions,omitempty" bson:"mentions,omitempty"`
+	MentionAll bool     `json:"mentionAll,omitempty" bson:"mentionAll,omitempty"`
+}
+
+// Quote 被回复消息的摘要
+type Quote struct {
+	MessageID string `json:"messageID" bson:"messageID"`
+	From      string `json:"from" bson:"from"`
+	Type      string `json:"type" bson:"type"`
+	Content   string `json:"content" bson:"content"`
+	Recalled  bool   `json:"recalled,omitempty" bson:"recalled,omitempty"`
+}
+
+func NewChatMessage(message *model.ChatMessage) *ChatMessage {
+	return &ChatMessage{ChatMessage: *message}
+}
+
+// NewMessageID 生成服务端消息 ID, 按生成时间递增
+func NewMessageID() string {
+	return primitive.NewObjectID().Hex()
+}
+
+// InsertChatMessage 写入聊天消息, 同一 messageID 已写入过时视为成功
+func InsertChatMessage(message *ChatMessage) error {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	_, err := collection(CollectionChatMessage).InsertOne(ctx, message)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// InsertChatMessages 批量写入聊天消息, 同一 messageID 已写入过时视为成功
+// 返回值 errs 与 messages 一一对应, 记录单条写入失败的原因; err 非空表示整批失败, 其中部分消息可能已经写入
+func InsertChatMessages(messages []*ChatMessage) (errs []error, err error) {
+	if len(messages) == 0 {
+		return nil, nil
+	}
+	docs := make([]interface{}, 0, len(messages))
+	for _, message := range messages {
+		docs = append(docs, message)
+	}
+	ctx, cancel := timeoutContext()
+	defer cancel()
+
+	errs = make([]error, len(messages))
+	_, err = collection(CollectionChatMessage).InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err == nil {
+		return errs, nil
+	}
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return nil, err
+	}
+	for _, we := range bulkErr.WriteErrors {
+		if mongo.IsDuplicateKeyError(we) {
+			continue
+		}
+		if we.Index >= 0 && we.Index < len(errs) {
+			errs[we.Index] = we
+		}
+	}
+	return errs, nil
+}
+
+// MessageTime 从消息 ID 中取出服务端生成时间
+func MessageTime(messageID string) (time.Time, error) {
+	id, err := primitive.ObjectIDFromHex(messageID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return id.Timestamp(), nil
+}
+
+// GetChatMessage 不存在时返回 nil
+func GetChatMessage(messageID string) (*ChatMessage, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	message := &ChatMessage{}
+	err := collection(CollectionChatMessage).FindOne(ctx, bson.M{"messageID": messageID}).Decode(message)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// GetRoomMessagesByPage 按时间倒序分页, current 从 1 开始, 页内按时间正序返回
+// uid 删除过的消息不返回
+func GetRoomMessagesByPage(roomID, uid string, current, pageSize int64) ([]*ChatMessage, error) {
+	return findMessagesByPage(bson.M{"to": roomID}, uid, current, pageSize)
+}
+
+// GetThreadMessagesByPage 话题内的回复, 分页方式与 GetRoomMessagesByPage 相同, 不包含首条消息
+func GetThreadMessagesByPage(threadRoot, uid string, current, pageSize int64) ([]*ChatMessage, error) {
+	return findMessagesByPage(bson.M{"threadRoot": threadRoot}, uid, current, pageSize)
+}
+
+func findMessagesByPage(filter bson.M, uid string, current, pageSize int64) ([]*ChatMessage, error) {
+	filter["deletedFor"] = bson.M{"$ne": uid}
+	if current < 1 {
+		current = 1
+	}
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetSkip((current - 1) * pageSize).SetLimit(pageSize)
+	cursor, err := collection(CollectionChatMessage).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*ChatMessage, 0, pageSize)
+	if err = cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+// GetRoomMessagesAround 以消息 ID 为游标, before 为 true 时返回早于 anchor 的消息, 否则返回晚于 anchor 的消息
+// anchor 为空时从最新或最早的消息开始; 结果按时间正序, 最多 limit 条, hasMore 表示同方向还有更多
+// 消息 ID 分配之前写入的旧消息没有 messageID, 只能通过 GetRoomMessagesByPage 读取
+func GetRoomMessagesAround(roomID, uid, anchor string, before bool, limit int64) (messages []*ChatMessage, hasMore bool, err error) {
+	// $gt "" 同时排除了没有 messageID 的旧消息
+	idFilter := bson.M{"$gt": ""}
+	order := 1
+	if before {
+		order = -1
+		if len(anchor) > 0 {
+			idFilter["$lt"] = anchor
+		}
+	} else if len(anchor) > 0 {
+		idFilter["$gt"] = anchor
+	}
+	filter := bson.M{"to": roomID, "messageID": idFilter, "deletedFor": bson.M{"$ne": uid}}
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	opts := options.Find().SetSort(bson.M{"messageID": order}).SetLimit(limit + 1)
+	cursor, err := collection(CollectionChatMessage).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, false, err
+	}
+	messages = make([]*ChatMessage, 0, limit+1)
+	if err = cursor.All(ctx, &messages); err != nil {
+		return nil, false, err
+	}
+	if int64(len(messages)) > limit {
+		hasMore = true
+		messages = messages[:limit]
+	}
+	if before {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	return messages, hasMore, nil
+}
+
+// MessageIDAt 返回 t 时刻对应的最小消息 ID, 用于按时间定位游标
+func MessageIDAt(t time.Time) string {
+	return primitive.NewObjectIDFromTimestamp(t).Hex()
+}
+
+// RecallChatMessage 清空内容并标记撤回, 返回是否更新成功
+func RecallChatMessage(messageID string) (bool, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	result, err := collection(CollectionChatMessage).UpdateOne(ctx,
+		bson.M{"messageID": messageID, "recalled": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"recalled": true, "content": "", "fileName": ""}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// EditChatMessage 先保存旧内容再替换为新内容, 已撤回或已被改动的消息不能编辑
+// 替换失败或未命中时删除刚保存的旧内容, 保证历史记录与消息一致
+func EditChatMessage(message *ChatMessage, content string, editTime time.Time) (bool, error) {
+	ms := editTime.UnixNano() / int64(time.Millisecond)
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	inserted, err := collection(CollectionChatMessageEdit).InsertOne(ctx, &ChatMessageEdit{
+		MessageID: message.MessageID,
+		Content:   message.Content,
+		EditTime:  ms,
+	})
+	if err != nil {
+		return false, err
+	}
+	result, err := collection(CollectionChatMessage).UpdateOne(ctx,
+		bson.M{"messageID": message.MessageID, "content": message.Content, "recalled": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"content": content, "editTime": ms}})
+	if err == nil && result.ModifiedCount > 0 {
+		return true, nil
+	}
+	if _, dErr := collection(CollectionChatMessageEdit).DeleteOne(ctx, bson.M{"_id": inserted.InsertedID}); dErr != nil && err == nil {
+		err = dErr
+	}
+	return false, err
+}
+
+// GetChatMessageEdits 按编辑时间正序返回历史内容
+func GetChatMessageEdits(messageID string) ([]*ChatMessageEdit, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	cursor, err := collection(CollectionChatMessageEdit).Find(ctx, bson.M{"messageID": messageID},
+		options.Find().SetSort(bson.M{"editTime": 1}))
+	if err != nil {
+		return nil, err
+	}
+	edits := make([]*ChatMessageEdit, 0)
+	err = cursor.All(ctx, &edits)
+	return edits, err
+}
+
+// DeleteChatMessageFor 只对 uid 隐藏
+func DeleteChatMessageFor(messageID, uid string) error {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	_, err := collection(CollectionChatMessage).UpdateOne(ctx, bson.M{"messageID": messageID},
+		bson.M{"$addToSet": bson.M{"deletedFor": uid}})
+	return err
+}
+
+// MessageSearch 消息搜索条件, RoomIDs 为空时不返回结果
+type MessageSearch struct {
+	RoomIDs []string
+	// Query 按内容不区分大小写的子串匹配
+	Query string
+	From  string
+	Type  string
+	Start time.Time
+	End   time.Time
+	// Before 只返回消息 ID 小于它的消息, 用于翻页
+	Before string
+	Limit  int64
+}
+
+// SearchChatMessages 按消息 ID 倒序返回 uid 可见且未撤回的匹配消息, hasMore 表示还有更早的结果
+// 内容按子串匹配, 文本索引按词切分无法支持中文子串, 所以由 {to, messageID} 索引限定在可访问房间内倒序扫描
+func SearchChatMessages(uid string, search *MessageSearch) ([]*ChatMessage, bool, error) {
+	if len(search.RoomIDs) == 0 {
+		return []*ChatMessage{}, false, nil
+	}
+	idFilter := bson.M{"$gt": ""}
+	if !search.Start.IsZero() {
+		idFilter["$gte"] = MessageIDAt(search.Start)
+	}
+	if len(search.Before) > 0 {
+		idFilter["$lt"] = search.Before
+	}
+	if !search.End.IsZero() {
+		end := MessageIDAt(search.End)
+		if lt, ok := idFilter["$lt"].(string); !ok || end < lt {
+			idFilter["$lt"] = end
+		}
+	}
+	filter := bson.M{
+		"to":         bson.M{"$in": search.RoomIDs},
+		"messageID":  idFilter,
+		"content":    primitive.Regex{Pattern: regexp.QuoteMeta(search.Query), Options: "i"},
+		"recalled":   bson.M{"$ne": true},
+		"deletedFor": bson.M{"$ne": uid},
+	}
+	if len(search.From) > 0 {
+		filter["from"] = search.From
+	}
+	if len(search.Type) > 0 {
+		filter["type"] = search.Type
+	}
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	opts := options.Find().SetSort(bson.M{"messageID": -1}).SetLimit(search.Limit + 1)
+	cursor, err := collection(CollectionChatMessage).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, false, err
+	}
+	messages := make([]*ChatMessage, 0, search.Limit+1)
+	if err = cursor.All(ctx, &messages); err != nil {
+		return nil, false, err
+	}
+	hasMore := int64(len(messages)) > search.Limit
+	if hasMore {
+		messages = messages[:search.Limit]
+	}
+	return messages, hasMore, nil
+}
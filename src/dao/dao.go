@@ -0,0 +1,40 @@
+// Package dao
+// @Title  dao.go
+// @Description  logic 服务自有的存储访问, 复用 framework/db 初始化的 mongo 与 redis 客户端
+// @Author  peanut996
+package dao
+
+import (
+	"context"
+	"framework/db"
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+const (
+	// CollectionChatMessage 与 framework/api/model 中聊天消息集合保持一致
+	CollectionChatMessage = "chatMessage"
+
+	defaultTimeout = 5 * time.Second
+)
+
+func collection(name string) *mongo.Collection {
+	return db.GetLastMongoClient().Database.Collection(name)
+}
+
+// redisClient 测试中通过 UseRedisClient 替换
+var redisClient = func() *redis.Client {
+	return db.GetLastRedisClient()
+}
+
+// UseRedisClient 让 dao 改用 client, 返回恢复原客户端的函数, 供其他包的测试使用内存中的 redis
+func UseRedisClient(client *redis.Client) (restore func()) {
+	previous := redisClient
+	redisClient = func() *redis.Client { return client }
+	return func() { redisClient = previous }
+}
+
+func timeoutContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), defaultTimeout)
+}
@@ -0,0 +1,20 @@
+package dao
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"testing"
+)
+
+// useMiniredis 在测试期间把 redisClient 指向内存中的 miniredis
+func useMiniredis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	restore := UseRedisClient(client)
+	t.Cleanup(func() {
+		restore()
+		client.Close()
+	})
+	return mr
+}
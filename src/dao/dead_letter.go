@@ -0,0 +1,74 @@
+package dao
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const CollectionInvokeDeadLetter = "invokeDeadLetter"
+
+// DeadLetter 重试耗尽后仍推送失败的 invoke 请求
+// Data 为推送内容的 json, 重放时原样发出
+type DeadLetter struct {
+	ID         string    `json:"id" bson:"id"`
+	Gate       string    `json:"gate" bson:"gate"`
+	Event      string    `json:"event" bson:"event"`
+	Targets    []string  `json:"targets" bson:"targets"`
+	Data       string    `json:"data" bson:"data"`
+	Error      string    `json:"error" bson:"error"`
+	Attempts   int       `json:"attempts" bson:"attempts"`
+	Replays    int       `json:"replays" bson:"replays"`
+	CreateTime time.Time `json:"createTime" bson:"createTime"`
+	UpdateTime time.Time `json:"updateTime" bson:"updateTime"`
+}
+
+func InsertDeadLetter(letter *DeadLetter) error {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	_, err := collection(CollectionInvokeDeadLetter).InsertOne(ctx, letter)
+	return err
+}
+
+// GetDeadLetters 按时间倒序返回, event 为空时不过滤
+func GetDeadLetters(event string, limit int64) ([]*DeadLetter, error) {
+	filter := bson.M{}
+	if len(event) > 0 {
+		filter["event"] = event
+	}
+	return findDeadLetters(filter, options.Find().SetSort(bson.M{"createTime": -1}).SetLimit(limit))
+}
+
+func GetDeadLettersByIDs(ids []string) ([]*DeadLetter, error) {
+	return findDeadLetters(bson.M{"id": bson.M{"$in": ids}}, options.Find().SetSort(bson.M{"createTime": 1}))
+}
+
+func findDeadLetters(filter bson.M, opts *options.FindOptions) ([]*DeadLetter, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	cursor, err := collection(CollectionInvokeDeadLetter).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]*DeadLetter, 0)
+	err = cursor.All(ctx, &letters)
+	return letters, err
+}
+
+func DeleteDeadLetter(id string) error {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	_, err := collection(CollectionInvokeDeadLetter).DeleteOne(ctx, bson.M{"id": id})
+	return err
+}
+
+// MarkDeadLetterReplayFailed 记录一次失败的重放
+func MarkDeadLetterReplayFailed(id string, cause error) error {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	_, err := collection(CollectionInvokeDeadLetter).UpdateOne(ctx, bson.M{"id": id}, bson.M{
+		"$set": bson.M{"error": cause.Error(), "updateTime": time.Now()},
+		"$inc": bson.M{"replays": 1},
+	})
+	return err
+}
@@ -0,0 +1,64 @@
+package dao
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	chatDedupeKey     = "logic:chat:dedupe:%v:%v"
+	chatDedupePending = "pending"
+	// chatDedupeClaimTTL 占用状态的最长保留时间, 进程在发送途中退出时客户端最多等待这么久即可重试
+	chatDedupeClaimTTL = 15 * time.Second
+)
+
+// ErrChatInProgress 同一 clientMsgID 的消息仍在处理中
+var ErrChatInProgress = errors.New("chat message with the same client id is in progress")
+
+// ClaimChatClientID 占用 (sender, clientMsgID), 占用成功返回 claimed=true
+// 已存在时返回首次发送的消息; 首次发送尚未完成时返回 ErrChatInProgress
+func ClaimChatClientID(from, clientMsgID string, window time.Duration) (original *ChatMessage, claimed bool, err error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	key := fmt.Sprintf(chatDedupeKey, from, clientMsgID)
+	claimTTL := window
+	if claimTTL > chatDedupeClaimTTL {
+		claimTTL = chatDedupeClaimTTL
+	}
+	claimed, err = redisClient().SetNX(ctx, key, chatDedupePending, claimTTL).Result()
+	if err != nil || claimed {
+		return nil, claimed, err
+	}
+	raw, err := redisClient().Get(ctx, key).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	if raw == chatDedupePending {
+		return nil, false, ErrChatInProgress
+	}
+	original = &ChatMessage{}
+	if err = json.Unmarshal([]byte(raw), original); err != nil {
+		return nil, false, err
+	}
+	return original, false, nil
+}
+
+// SaveChatClientID 首次发送成功后记录服务端消息, 供重试时返回
+func SaveChatClientID(message *ChatMessage, window time.Duration) error {
+	raw, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	return redisClient().Set(ctx, fmt.Sprintf(chatDedupeKey, message.From, message.ClientMsgID), raw, window).Err()
+}
+
+// ReleaseChatClientID 首次发送或记录失败时释放占用, 允许客户端重试
+func ReleaseChatClientID(from, clientMsgID string) error {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	return redisClient().Del(ctx, fmt.Sprintf(chatDedupeKey, from, clientMsgID)).Err()
+}
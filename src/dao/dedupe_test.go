@@ -0,0 +1,71 @@
+package dao
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestChatClientIDDedupe(t *testing.T) {
+	mr := useMiniredis(t)
+	const window = time.Minute
+	key := fmt.Sprintf(chatDedupeKey, "u1", "c1")
+
+	_, claimed, err := ClaimChatClientID("u1", "c1", window)
+	if err != nil || !claimed {
+		t.Fatalf("first claim = %v, %v, want claimed", claimed, err)
+	}
+	if ttl := mr.TTL(key); ttl != chatDedupeClaimTTL {
+		t.Fatalf("claim ttl = %v, want %v", ttl, chatDedupeClaimTTL)
+	}
+	if _, _, err = ClaimChatClientID("u1", "c1", window); err != ErrChatInProgress {
+		t.Fatalf("claim while in progress err = %v, want ErrChatInProgress", err)
+	}
+	if _, claimed, err = ClaimChatClientID("u2", "c1", window); err != nil || !claimed {
+		t.Fatalf("claim by another sender = %v, %v, want claimed", claimed, err)
+	}
+
+	message := &ChatMessage{}
+	message.From, message.ClientMsgID, message.MessageID = "u1", "c1", "m1"
+	if err = SaveChatClientID(message, window); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL(key); ttl != window {
+		t.Fatalf("saved ttl = %v, want %v", ttl, window)
+	}
+	original, claimed, err := ClaimChatClientID("u1", "c1", window)
+	if err != nil || claimed || original == nil || original.MessageID != "m1" {
+		t.Fatalf("claim after save = %+v, %v, %v, want original m1", original, claimed, err)
+	}
+
+	mr.FastForward(window)
+	if _, claimed, err = ClaimChatClientID("u1", "c1", window); err != nil || !claimed {
+		t.Fatalf("claim after window = %v, %v, want claimed", claimed, err)
+	}
+	if err = ReleaseChatClientID("u1", "c1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, claimed, err = ClaimChatClientID("u1", "c1", window); err != nil || !claimed {
+		t.Fatalf("claim after release = %v, %v, want claimed", claimed, err)
+	}
+}
+
+func TestChatClientIDClaimTTL(t *testing.T) {
+	mr := useMiniredis(t)
+	cases := []struct {
+		window, want time.Duration
+	}{
+		{5 * time.Second, 5 * time.Second},
+		{chatDedupeClaimTTL, chatDedupeClaimTTL},
+		{time.Hour, chatDedupeClaimTTL},
+	}
+	for i, c := range cases {
+		clientMsgID := fmt.Sprintf("c%v", i)
+		if _, _, err := ClaimChatClientID("u1", clientMsgID, c.window); err != nil {
+			t.Fatal(err)
+		}
+		if ttl := mr.TTL(fmt.Sprintf(chatDedupeKey, "u1", clientMsgID)); ttl != c.want {
+			t.Errorf("window %v claim ttl = %v, want %v", c.window, ttl, c.want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package dao
+
+import (
+	"framework/api/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"regexp"
+)
+
+const (
+	// CollectionUser 与 framework/api/model 中用户集合保持一致
+	CollectionUser = "user"
+	// CollectionGroup 与 framework/api/model 中群组集合保持一致
+	CollectionGroup = "group"
+)
+
+// matchTiers 依次为完全匹配, 前缀匹配, 模糊匹配, 均不区分大小写
+func matchTiers(field, query string) []bson.M {
+	quoted := regexp.QuoteMeta(query)
+	patterns := []string{"^" + quoted + "$", "^" + quoted, quoted}
+	tiers := make([]bson.M, 0, len(patterns))
+	for _, pattern := range patterns {
+		tiers = append(tiers, bson.M{field: primitive.Regex{Pattern: pattern, Options: "i"}})
+	}
+	return tiers
+}
+
+// FindUserCandidates 按匹配程度分三次查询, 每次最多 limit 条, 避免模糊匹配挤掉完全匹配与前缀匹配
+// 结果按 uid 去重, 不包含密码
+func FindUserCandidates(query string, limit int64) ([]*model.User, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	opts := options.Find().SetLimit(limit).SetSort(bson.M{"account": 1}).SetProjection(bson.M{"password": 0})
+	seen := make(map[string]bool)
+	users := make([]*model.User, 0)
+	for _, filter := range matchTiers("account", query) {
+		cursor, err := collection(CollectionUser).Find(ctx, filter, opts)
+		if err != nil {
+			return nil, err
+		}
+		tier := make([]*model.User, 0)
+		if err = cursor.All(ctx, &tier); err != nil {
+			return nil, err
+		}
+		for _, user := range tier {
+			if !seen[user.UID] {
+				seen[user.UID] = true
+				users = append(users, user)
+			}
+		}
+	}
+	return users, nil
+}
+
+// FindGroupCandidates 与 FindUserCandidates 相同, 按群组名称匹配, 结果按 groupID 去重
+func FindGroupCandidates(query string, limit int64) ([]*model.Group, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	opts := options.Find().SetLimit(limit).SetSort(bson.M{"groupName": 1})
+	seen := make(map[string]bool)
+	groups := make([]*model.Group, 0)
+	for _, filter := range matchTiers("groupName", query) {
+		cursor, err := collection(CollectionGroup).Find(ctx, filter, opts)
+		if err != nil {
+			return nil, err
+		}
+		tier := make([]*model.Group, 0)
+		if err = cursor.All(ctx, &tier); err != nil {
+			return nil, err
+		}
+		for _, group := range tier {
+			if !seen[group.GroupID] {
+				seen[group.GroupID] = true
+				groups = append(groups, group)
+			}
+		}
+	}
+	return groups, nil
+}
@@ -0,0 +1,111 @@
+package dao
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+const CollectionFriendRequest = "friendRequest"
+
+const (
+	FriendRequestPending  = "pending"
+	FriendRequestAccepted = "accepted"
+	FriendRequestRejected = "rejected"
+	FriendRequestCanceled = "canceled"
+)
+
+// FriendRequest 好友申请
+type FriendRequest struct {
+	RequestID  string    `json:"requestID" bson:"requestID"`
+	From       string    `json:"from" bson:"from"`
+	To         string    `json:"to" bson:"to"`
+	Greeting   string    `json:"greeting" bson:"greeting"`
+	Status     string    `json:"status" bson:"status"`
+	CreateTime time.Time `json:"createTime" bson:"createTime"`
+	UpdateTime time.Time `json:"updateTime" bson:"updateTime"`
+}
+
+func NewFriendRequest(from, to, greeting string) *FriendRequest {
+	now := time.Now()
+	return &FriendRequest{
+		RequestID:  NewMessageID(),
+		From:       from,
+		To:         to,
+		Greeting:   greeting,
+		Status:     FriendRequestPending,
+		CreateTime: now,
+		UpdateTime: now,
+	}
+}
+
+func InsertFriendRequest(request *FriendRequest) error {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	_, err := collection(CollectionFriendRequest).InsertOne(ctx, request)
+	return err
+}
+
+func findFriendRequest(filter bson.M) (*FriendRequest, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	request := &FriendRequest{}
+	err := collection(CollectionFriendRequest).FindOne(ctx, filter).Decode(request)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// GetFriendRequest 不存在时返回 nil
+func GetFriendRequest(requestID string) (*FriendRequest, error) {
+	return findFriendRequest(bson.M{"requestID": requestID})
+}
+
+// GetPendingFriendRequest 返回 from 发给 to 的待处理申请, 不存在时返回 nil
+func GetPendingFriendRequest(from, to string) (*FriendRequest, error) {
+	return findFriendRequest(bson.M{"from": from, "to": to, "status": FriendRequestPending})
+}
+
+// GetPendingFriendRequestsByUID 返回用户收到和发出的待处理申请
+func GetPendingFriendRequestsByUID(uid string) ([]*FriendRequest, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	cursor, err := collection(CollectionFriendRequest).Find(ctx, bson.M{
+		"status": FriendRequestPending,
+		"$or":    bson.A{bson.M{"from": uid}, bson.M{"to": uid}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	requests := make([]*FriendRequest, 0)
+	err = cursor.All(ctx, &requests)
+	return requests, err
+}
+
+// RevertFriendRequest 处理失败时把 status 状态的申请恢复为 pending
+func RevertFriendRequest(requestID, status string) error {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	_, err := collection(CollectionFriendRequest).UpdateOne(ctx,
+		bson.M{"requestID": requestID, "status": status},
+		bson.M{"$set": bson.M{"status": FriendRequestPending, "updateTime": time.Now()}})
+	return err
+}
+
+// UpdateFriendRequest 只更新仍处于 pending 的申请, 返回是否更新成功
+func UpdateFriendRequest(request *FriendRequest) (bool, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	request.UpdateTime = time.Now()
+	result, err := collection(CollectionFriendRequest).UpdateOne(ctx,
+		bson.M{"requestID": request.RequestID, "status": FriendRequestPending},
+		bson.M{"$set": bson.M{"status": request.Status, "greeting": request.Greeting, "updateTime": request.UpdateTime}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
@@ -0,0 +1,39 @@
+package dao
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const CollectionGroupBan = "groupBan"
+
+// GroupBan 被禁止加入群组的用户
+type GroupBan struct {
+	GroupID  string    `json:"groupID" bson:"groupID"`
+	UID      string    `json:"uid" bson:"uid"`
+	Operator string    `json:"operator" bson:"operator"`
+	Time     time.Time `json:"time" bson:"time"`
+}
+
+func AddGroupBan(ban *GroupBan) error {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	_, err := collection(CollectionGroupBan).ReplaceOne(ctx,
+		bson.M{"groupID": ban.GroupID, "uid": ban.UID}, ban, options.Replace().SetUpsert(true))
+	return err
+}
+
+func DeleteGroupBan(groupID, uid string) error {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	_, err := collection(CollectionGroupBan).DeleteOne(ctx, bson.M{"groupID": groupID, "uid": uid})
+	return err
+}
+
+func IsGroupBanned(groupID, uid string) (bool, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	n, err := collection(CollectionGroupBan).CountDocuments(ctx, bson.M{"groupID": groupID, "uid": uid})
+	return n > 0, err
+}
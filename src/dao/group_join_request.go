@@ -0,0 +1,110 @@
+package dao
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const CollectionGroupJoinRequest = "groupJoinRequest"
+
+const (
+	GroupJoinPending  = "pending"
+	GroupJoinApproved = "approved"
+	GroupJoinRejected = "rejected"
+)
+
+// GroupJoinRequest 需要审批的群组的入群申请
+type GroupJoinRequest struct {
+	RequestID string `json:"requestID" bson:"requestID"`
+	GroupID   string `json:"groupID" bson:"groupID"`
+	UID       string `json:"uid" bson:"uid"`
+	Status    string `json:"status" bson:"status"`
+	// Operator 审批的管理员
+	Operator   string    `json:"operator,omitempty" bson:"operator,omitempty"`
+	CreateTime time.Time `json:"createTime" bson:"createTime"`
+	UpdateTime time.Time `json:"updateTime" bson:"updateTime"`
+}
+
+func NewGroupJoinRequest(groupID, uid string) *GroupJoinRequest {
+	now := time.Now()
+	return &GroupJoinRequest{
+		RequestID:  NewMessageID(),
+		GroupID:    groupID,
+		UID:        uid,
+		Status:     GroupJoinPending,
+		CreateTime: now,
+		UpdateTime: now,
+	}
+}
+
+func InsertGroupJoinRequest(request *GroupJoinRequest) error {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	_, err := collection(CollectionGroupJoinRequest).InsertOne(ctx, request)
+	return err
+}
+
+func findGroupJoinRequest(filter bson.M) (*GroupJoinRequest, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	request := &GroupJoinRequest{}
+	err := collection(CollectionGroupJoinRequest).FindOne(ctx, filter).Decode(request)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// GetGroupJoinRequest 不存在时返回 nil
+func GetGroupJoinRequest(requestID string) (*GroupJoinRequest, error) {
+	return findGroupJoinRequest(bson.M{"requestID": requestID})
+}
+
+// GetPendingGroupJoinRequest 返回用户对群组的待审批申请, 不存在时返回 nil
+func GetPendingGroupJoinRequest(groupID, uid string) (*GroupJoinRequest, error) {
+	return findGroupJoinRequest(bson.M{"groupID": groupID, "uid": uid, "status": GroupJoinPending})
+}
+
+// GetPendingGroupJoinRequests 按申请时间正序返回群组的待审批申请
+func GetPendingGroupJoinRequests(groupID string) ([]*GroupJoinRequest, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	cursor, err := collection(CollectionGroupJoinRequest).Find(ctx,
+		bson.M{"groupID": groupID, "status": GroupJoinPending},
+		options.Find().SetSort(bson.M{"createTime": 1}))
+	if err != nil {
+		return nil, err
+	}
+	requests := make([]*GroupJoinRequest, 0)
+	err = cursor.All(ctx, &requests)
+	return requests, err
+}
+
+// UpdateGroupJoinRequest 只更新仍处于 pending 的申请, 返回是否更新成功
+func UpdateGroupJoinRequest(request *GroupJoinRequest) (bool, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	request.UpdateTime = time.Now()
+	result, err := collection(CollectionGroupJoinRequest).UpdateOne(ctx,
+		bson.M{"requestID": request.RequestID, "status": GroupJoinPending},
+		bson.M{"$set": bson.M{"status": request.Status, "operator": request.Operator, "updateTime": request.UpdateTime}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// RevertGroupJoinRequest 处理失败时把 status 状态的申请恢复为 pending 并清除审批人
+func RevertGroupJoinRequest(requestID, status string) error {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	_, err := collection(CollectionGroupJoinRequest).UpdateOne(ctx,
+		bson.M{"requestID": requestID, "status": status},
+		bson.M{"$set": bson.M{"status": GroupJoinPending, "updateTime": time.Now()}, "$unset": bson.M{"operator": ""}})
+	return err
+}
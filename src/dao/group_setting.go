@@ -0,0 +1,97 @@
+package dao
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const CollectionGroupSetting = "groupSetting"
+
+const (
+	GroupJoinOpen       = "open"
+	GroupJoinApproval   = "approval"
+	GroupJoinInviteOnly = "inviteOnly"
+)
+
+// GroupSetting 群组的可见性与加入方式, 没有记录的群组为公开且可直接加入
+type GroupSetting struct {
+	GroupID string `json:"groupID" bson:"groupID"`
+	// Private 私有群组不出现在搜索结果中
+	Private    bool      `json:"private" bson:"private"`
+	JoinPolicy string    `json:"joinPolicy" bson:"joinPolicy"`
+	UpdateTime time.Time `json:"updateTime" bson:"updateTime"`
+}
+
+func defaultGroupSetting(groupID string) *GroupSetting {
+	return &GroupSetting{GroupID: groupID, JoinPolicy: GroupJoinOpen}
+}
+
+// GetGroupSetting 没有记录时返回默认设置
+func GetGroupSetting(groupID string) (*GroupSetting, error) {
+	settings, err := GetGroupSettings([]string{groupID})
+	if err != nil {
+		return nil, err
+	}
+	return settings[groupID], nil
+}
+
+// GetGroupSettings 返回每个群组的设置, 没有记录的群组为默认设置
+func GetGroupSettings(groupIDs []string) (map[string]*GroupSetting, error) {
+	settings := make(map[string]*GroupSetting, len(groupIDs))
+	if len(groupIDs) == 0 {
+		return settings, nil
+	}
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	cursor, err := collection(CollectionGroupSetting).Find(ctx, bson.M{"groupID": bson.M{"$in": groupIDs}})
+	if err != nil {
+		return nil, err
+	}
+	stored := make([]*GroupSetting, 0)
+	if err = cursor.All(ctx, &stored); err != nil {
+		return nil, err
+	}
+	for _, groupID := range groupIDs {
+		settings[groupID] = defaultGroupSetting(groupID)
+	}
+	for _, setting := range stored {
+		if len(setting.JoinPolicy) == 0 {
+			setting.JoinPolicy = GroupJoinOpen
+		}
+		settings[setting.GroupID] = setting
+	}
+	return settings, nil
+}
+
+// UpdateGroupSetting 只 $set 非 nil 的字段, 并发修改不同字段互不覆盖; 没有记录时以默认值补齐其余字段
+func UpdateGroupSetting(groupID string, private *bool, joinPolicy *string) (*GroupSetting, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	set := bson.M{"updateTime": time.Now()}
+	setOnInsert := bson.M{}
+	if private != nil {
+		set["private"] = *private
+	} else {
+		setOnInsert["private"] = false
+	}
+	if joinPolicy != nil {
+		set["joinPolicy"] = *joinPolicy
+	} else {
+		setOnInsert["joinPolicy"] = GroupJoinOpen
+	}
+	update := bson.M{"$set": set}
+	if len(setOnInsert) > 0 {
+		update["$setOnInsert"] = setOnInsert
+	}
+	setting := &GroupSetting{}
+	err := collection(CollectionGroupSetting).FindOneAndUpdate(ctx, bson.M{"groupID": groupID}, update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(setting)
+	if err != nil {
+		return nil, err
+	}
+	if len(setting.JoinPolicy) == 0 {
+		setting.JoinPolicy = GroupJoinOpen
+	}
+	return setting, nil
+}
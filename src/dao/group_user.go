@@ -0,0 +1,167 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"framework/api/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CollectionGroupUser 与 framework/api/model 中群成员集合保持一致
+const CollectionGroupUser = "groupUser"
+
+// GroupUser 在 framework 群成员的基础上附加角色, 旧数据没有 role 字段
+type GroupUser struct {
+	model.GroupUser `bson:",inline"`
+	Role            string `json:"role" bson:"role,omitempty"`
+}
+
+// GetGroupUser 成员不存在时返回 nil
+func GetGroupUser(groupID, uid string) (*GroupUser, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	gUser := &GroupUser{}
+	err := collection(CollectionGroupUser).FindOne(ctx, bson.M{"groupID": groupID, "uid": uid}).Decode(gUser)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return gUser, nil
+}
+
+func SetGroupRole(groupID, uid, role string) error {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	_, err := collection(CollectionGroupUser).UpdateOne(ctx,
+		bson.M{"groupID": groupID, "uid": uid},
+		bson.M{"$set": bson.M{"role": role}})
+	return err
+}
+
+// GetGroupUIDsByRoles 返回群组中拥有指定角色的成员, 不包含没有 role 字段的旧数据
+func GetGroupUIDsByRoles(groupID string, roles ...string) ([]string, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	cursor, err := collection(CollectionGroupUser).Find(ctx, bson.M{"groupID": groupID, "role": bson.M{"$in": roles}})
+	if err != nil {
+		return nil, err
+	}
+	gUsers := make([]*GroupUser, 0)
+	if err = cursor.All(ctx, &gUsers); err != nil {
+		return nil, err
+	}
+	uids := make([]string, 0, len(gUsers))
+	for _, gUser := range gUsers {
+		uids = append(uids, gUser.UID)
+	}
+	return uids, nil
+}
+
+// errOwnerChanged 事务内条件不满足, 回滚整个转让
+var errOwnerChanged = errors.New("group owner changed")
+
+// errTransactionNotSupported 单机 mongo 不支持事务时返回的错误码 IllegalOperation
+const errTransactionNotSupported = 20
+
+// TransferGroupOwner 把 from 降为 roleFrom, to 升为 roleOwner
+// from 须仍是群主 (role 为 owner 或旧数据没有 role), to 须是群成员, 否则不做修改并返回 false
+// 副本集与分片集群上在一个事务中完成; 单机 mongo 不支持事务, 改为依次执行两次条件更新, 升级失败时恢复原群主
+func TransferGroupOwner(groupID, from, to, roleOwner, roleFrom string) (bool, error) {
+	transferred, err := transferGroupOwnerInTransaction(groupID, from, to, roleOwner, roleFrom)
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(errTransactionNotSupported) {
+		return transferGroupOwnerOrdered(groupID, from, to, roleOwner, roleFrom)
+	}
+	return transferred, err
+}
+
+func transferGroupOwnerInTransaction(groupID, from, to, roleOwner, roleFrom string) (bool, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	coll := collection(CollectionGroupUser)
+	session, err := coll.Database().Client().StartSession()
+	if err != nil {
+		return false, err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, swapGroupOwner(sc, coll, groupID, from, to, roleOwner, roleFrom)
+	})
+	if errors.Is(err, errOwnerChanged) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// transferGroupOwnerOrdered 先降级原群主再升级新群主, 升级未生效时把原群主恢复为 roleOwner
+// 两次更新之间群组短暂没有群主, 期间的群主操作会被拒绝
+func transferGroupOwnerOrdered(groupID, from, to, roleOwner, roleFrom string) (bool, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	coll := collection(CollectionGroupUser)
+	err := swapGroupOwner(ctx, coll, groupID, from, to, roleOwner, roleFrom)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, errOwnerDemoted) {
+		_, rErr := coll.UpdateOne(ctx,
+			bson.M{"groupID": groupID, "uid": from, "role": roleFrom},
+			bson.M{"$set": bson.M{"role": roleOwner}})
+		if rErr != nil {
+			return false, rErr
+		}
+		err = errors.Unwrap(err)
+	}
+	if errors.Is(err, errOwnerChanged) {
+		return false, nil
+	}
+	return false, err
+}
+
+// errOwnerDemoted 原群主已降级但新群主升级失败, 包装升级的错误
+var errOwnerDemoted = errors.New("group owner demoted")
+
+type demotedError struct {
+	cause error
+}
+
+func (e *demotedError) Error() string {
+	return errOwnerDemoted.Error() + ": " + e.cause.Error()
+}
+
+func (e *demotedError) Is(target error) bool {
+	return target == errOwnerDemoted
+}
+
+func (e *demotedError) Unwrap() error {
+	return e.cause
+}
+
+// swapGroupOwner 依次降级 from 与升级 to, 升级失败时返回 demotedError
+func swapGroupOwner(ctx context.Context, coll *mongo.Collection, groupID, from, to, roleOwner, roleFrom string) error {
+	result, err := coll.UpdateOne(ctx,
+		bson.M{"groupID": groupID, "uid": from, "role": bson.M{"$in": bson.A{roleOwner, nil}}},
+		bson.M{"$set": bson.M{"role": roleFrom}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errOwnerChanged
+	}
+	result, err = coll.UpdateOne(ctx,
+		bson.M{"groupID": groupID, "uid": to},
+		bson.M{"$set": bson.M{"role": roleOwner}})
+	if err == nil && result.MatchedCount == 0 {
+		err = errOwnerChanged
+	}
+	if err != nil {
+		return &demotedError{cause: err}
+	}
+	return nil
+}
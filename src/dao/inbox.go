@@ -0,0 +1,181 @@
+package dao
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+const (
+	inboxKey      = "logic:inbox:%v"
+	inboxMsgKey   = "logic:inbox:msg:%v"
+	inboxStateKey = "logic:inbox:state:%v"
+
+	InboxStatePending   = "pending"
+	InboxStateDelivered = "delivered"
+)
+
+// addInboxScript 一次调用写入所有接收者的离线消息, 超出上限时丢弃最旧的消息
+// KEYS 每个接收者依次为 顺序 消息 状态 三个 key; ARGV[1] 消息 ID ARGV[2] 排序分 ARGV[3] 消息内容 ARGV[4] 上限 ARGV[5] 过期秒数
+var addInboxScript = redis.NewScript(`
+for k = 1, #KEYS, 3 do
+	redis.call('ZADD', KEYS[k], ARGV[2], ARGV[1])
+	redis.call('HSET', KEYS[k+1], ARGV[1], ARGV[3])
+	local overflow = redis.call('ZCARD', KEYS[k]) - tonumber(ARGV[4])
+	if overflow > 0 then
+		local dropped = redis.call('ZPOPMIN', KEYS[k], overflow)
+		for i = 1, #dropped, 2 do
+			redis.call('HDEL', KEYS[k+1], dropped[i])
+			redis.call('HDEL', KEYS[k+2], dropped[i])
+		end
+	end
+	for i = 0, 2 do
+		redis.call('EXPIRE', KEYS[k+i], ARGV[5])
+	end
+end
+return 1
+`)
+
+// markDeliveredScript 一次调用标记所有接收者的消息已送达, 只标记仍在收件箱中的消息
+// KEYS 同 addInboxScript; ARGV[1] 消息 ID
+var markDeliveredScript = redis.NewScript(`
+for k = 1, #KEYS, 3 do
+	if redis.call('HEXISTS', KEYS[k+1], ARGV[1]) == 1 then
+		redis.call('HSET', KEYS[k+2], ARGV[1], 'delivered')
+	end
+end
+return 1
+`)
+
+// InboxMessage 收件箱中等待确认的消息
+type InboxMessage struct {
+	State   string       `json:"state"`
+	Message *ChatMessage `json:"message"`
+}
+
+func inboxKeys(uid string) []string {
+	return []string{fmt.Sprintf(inboxKey, uid), fmt.Sprintf(inboxMsgKey, uid), fmt.Sprintf(inboxStateKey, uid)}
+}
+
+func inboxKeysOf(uids []string) []string {
+	keys := make([]string, 0, 3*len(uids))
+	for _, uid := range uids {
+		keys = append(keys, inboxKeys(uid)...)
+	}
+	return keys
+}
+
+// AddToInbox 为每个接收者记录一条待确认消息, 所有接收者只执行一次脚本
+func AddToInbox(uids []string, message *ChatMessage, maxSize int, ttl time.Duration) error {
+	if len(uids) == 0 {
+		return nil
+	}
+	raw, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	score := time.Now().UnixNano()
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	return addInboxScript.Run(ctx, redisClient(), inboxKeysOf(uids), message.MessageID, score, string(raw), maxSize, int64(ttl/time.Second)).Err()
+}
+
+// MarkInboxDeliveredFor 一条消息推送成功后标记所有接收者已送达
+func MarkInboxDeliveredFor(uids []string, messageID string) error {
+	if len(uids) == 0 {
+		return nil
+	}
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	return markDeliveredScript.Run(ctx, redisClient(), inboxKeysOf(uids), messageID).Err()
+}
+
+// MarkInboxDelivered 记录已送达 gate, 等待客户端确认
+func MarkInboxDelivered(uid string, messageIDs ...string) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+	keys := inboxKeys(uid)
+	values := make(map[string]interface{}, len(messageIDs))
+	for _, id := range messageIDs {
+		values[id] = InboxStateDelivered
+	}
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	// 只标记仍在收件箱中的消息, 避免确认后又被写回状态
+	exists, err := redisClient().HMGet(ctx, keys[1], messageIDs...).Result()
+	if err != nil {
+		return err
+	}
+	for i, v := range exists {
+		if v == nil {
+			delete(values, messageIDs[i])
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return redisClient().HSet(ctx, keys[2], values).Err()
+}
+
+// GetInbox 按写入顺序返回最早的 limit 条待确认消息
+func GetInbox(uid string, limit int64) ([]*InboxMessage, error) {
+	keys := inboxKeys(uid)
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	ids, err := redisClient().ZRange(ctx, keys[0], 0, limit-1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	raws, err := redisClient().HMGet(ctx, keys[1], ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	states, err := redisClient().HMGet(ctx, keys[2], ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*InboxMessage, 0, len(ids))
+	for i := range ids {
+		raw, ok := raws[i].(string)
+		if !ok {
+			continue
+		}
+		message := &ChatMessage{}
+		if err = json.Unmarshal([]byte(raw), message); err != nil {
+			return nil, err
+		}
+		state, ok := states[i].(string)
+		if !ok {
+			state = InboxStatePending
+		}
+		messages = append(messages, &InboxMessage{State: state, Message: message})
+	}
+	return messages, nil
+}
+
+// AckInbox 客户端确认后移出收件箱, 返回实际移除的条数
+func AckInbox(uid string, messageIDs ...string) (int64, error) {
+	if len(messageIDs) == 0 {
+		return 0, nil
+	}
+	keys := inboxKeys(uid)
+	members := make([]interface{}, 0, len(messageIDs))
+	for _, id := range messageIDs {
+		members = append(members, id)
+	}
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	var removed *redis.IntCmd
+	_, err := redisClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		removed = pipe.ZRem(ctx, keys[0], members...)
+		pipe.HDel(ctx, keys[1], messageIDs...)
+		pipe.HDel(ctx, keys[2], messageIDs...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed.Val(), nil
+}
@@ -0,0 +1,60 @@
+package dao
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes 启动时创建 logic 自有查询依赖的索引, 已存在的索引不会重复创建
+func EnsureIndexes() error {
+	indexes := map[string][]mongo.IndexModel{
+		CollectionChatMessage: {
+			// 消息 ID 唯一, 队列重放或整批失败后逐条重写时由重复键拒绝第二份
+			// 旧消息没有 messageID, 只对存在该字段的消息建唯一索引, 否则已有数据上建索引会因重复的 null 失败
+			// 按 messageID 等值查询隐含字段存在, 仍可以使用该索引
+			{Keys: bson.D{{Key: "messageID", Value: 1}}, Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"messageID": bson.M{"$exists": true}})},
+			// 房间内按消息 ID 翻页与定位游标
+			{Keys: bson.D{{Key: "to", Value: 1}, {Key: "messageID", Value: 1}}},
+		},
+		CollectionChatMessageEdit: {
+			{Keys: bson.D{{Key: "messageID", Value: 1}, {Key: "editTime", Value: 1}}},
+		},
+		CollectionFriendRequest: {
+			{Keys: bson.D{{Key: "requestID", Value: 1}}, Options: options.Index().SetUnique(true)},
+			// 同一方向只能有一条待处理的申请, 并发发送时后到的插入失败
+			{Keys: bson.D{{Key: "from", Value: 1}, {Key: "to", Value: 1}}, Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"status": FriendRequestPending})},
+		},
+		CollectionUserBlock: {
+			// 屏蔽记录按 (uid, target) 唯一, 并发屏蔽时只保留一条
+			{Keys: bson.D{{Key: "uid", Value: 1}, {Key: "target", Value: 1}}, Options: options.Index().SetUnique(true)},
+			// 每条单聊消息都按 target 查询屏蔽了发送者的用户
+			{Keys: bson.D{{Key: "target", Value: 1}}},
+		},
+		CollectionReadCursor: {
+			// 每个用户在每个房间只有一条游标, 并发 upsert 时后到的插入失败后重试为更新
+			{Keys: bson.D{{Key: "uid", Value: 1}, {Key: "roomID", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		CollectionGroupJoinRequest: {
+			{Keys: bson.D{{Key: "requestID", Value: 1}}, Options: options.Index().SetUnique(true)},
+			// 每个用户对每个群组只能有一条待审批的申请, 并发提交时后到的插入失败
+			{Keys: bson.D{{Key: "groupID", Value: 1}, {Key: "uid", Value: 1}}, Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"status": GroupJoinPending})},
+		},
+		CollectionGroupSetting: {
+			// 并发 upsert 同一群组时只插入一条设置
+			{Keys: bson.D{{Key: "groupID", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+	}
+	for name, models := range indexes {
+		ctx, cancel := timeoutContext()
+		_, err := collection(name).Indexes().CreateMany(ctx, models)
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package dao
+
+import (
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"time"
+)
+
+const (
+	mentionKey    = "logic:mention:%v:%v"
+	mentionAllKey = "logic:mention:all:%v"
+
+	// mentionTTL 长期不活跃的群不再保留提及记录
+	mentionTTL = 30 * 24 * time.Hour
+)
+
+// AddMentions 以消息序号记录提及, all 为 true 时只记录房间级的 @all
+// 每个集合最多保留 max 条, 超出丢弃最早的
+func AddMentions(roomID string, seq int64, uids []string, all bool, max int) error {
+	keys := make([]string, 0, len(uids))
+	if all {
+		keys = append(keys, fmt.Sprintf(mentionAllKey, roomID))
+	} else {
+		for _, uid := range uids {
+			keys = append(keys, fmt.Sprintf(mentionKey, uid, roomID))
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	member := &redis.Z{Score: float64(seq), Member: seq}
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	_, err := redisClient().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.ZAdd(ctx, key, member)
+			if max > 0 {
+				pipe.ZRemRangeByRank(ctx, key, 0, int64(-max-1))
+			}
+			pipe.Expire(ctx, key, mentionTTL)
+		}
+		return nil
+	})
+	return err
+}
+
+// CountMentions 返回各房间中序号大于已读序号的提及数
+func CountMentions(uid string, readSeqs map[string]int64, roomIDs []string) (map[string]int64, error) {
+	counts := make(map[string]int64, len(roomIDs))
+	if len(roomIDs) == 0 {
+		return counts, nil
+	}
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	own := make([]*redis.IntCmd, len(roomIDs))
+	all := make([]*redis.IntCmd, len(roomIDs))
+	_, err := redisClient().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, roomID := range roomIDs {
+			min := "(" + strconv.FormatInt(readSeqs[roomID], 10)
+			own[i] = pipe.ZCount(ctx, fmt.Sprintf(mentionKey, uid, roomID), min, "+inf")
+			all[i] = pipe.ZCount(ctx, fmt.Sprintf(mentionAllKey, roomID), min, "+inf")
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	for i, roomID := range roomIDs {
+		if n := own[i].Val() + all[i].Val(); n > 0 {
+			counts[roomID] = n
+		}
+	}
+	return counts, nil
+}
+
+// ClearMentions 移除已读序号之前的个人提及, @all 由上限与过期时间清理
+func ClearMentions(uid, roomID string, seq int64) error {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	return redisClient().ZRemRangeByScore(ctx, fmt.Sprintf(mentionKey, uid, roomID), "-inf", strconv.FormatInt(seq, 10)).Err()
+}
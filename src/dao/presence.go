@@ -0,0 +1,217 @@
+package dao
+
+import (
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	presenceKey       = "logic:presence:%v"
+	lastSeenKey       = "logic:lastSeen"
+	presenceHiddenKey = "logic:presence:hidden"
+)
+
+// 连接记录是用户的一个 hash: 携带连接 ID 时字段为 gate#connID, 值固定为 1, 重复登记不会累加
+// 不携带连接 ID 的旧 gate 字段为 gate, 值为连接数
+
+// registerGateScript 增加一个连接, 返回 {登记前的连接总数, 登记后的连接总数}
+// ARGV[1] gate ARGV[2] 过期秒数 ARGV[3] 连接 ID, 可为空
+var registerGateScript = redis.NewScript(`
+local function total()
+	local n = 0
+	for _, v in ipairs(redis.call('HVALS', KEYS[1])) do
+		n = n + tonumber(v)
+	end
+	return n
+end
+local before = total()
+if ARGV[3] ~= '' then
+	redis.call('HSET', KEYS[1], ARGV[1] .. '#' .. ARGV[3], 1)
+else
+	redis.call('HINCRBY', KEYS[1], ARGV[1], 1)
+end
+redis.call('EXPIRE', KEYS[1], ARGV[2])
+return {before, total()}
+`)
+
+// releaseGateScript 减少一个连接, 连接数减到 0 时移除该字段, 返回剩余连接总数
+// ARGV 同 registerGateScript, 不含过期秒数
+var releaseGateScript = redis.NewScript(`
+if ARGV[2] ~= '' then
+	redis.call('HDEL', KEYS[1], ARGV[1] .. '#' .. ARGV[2])
+else
+	local n = redis.call('HINCRBY', KEYS[1], ARGV[1], -1)
+	if n <= 0 then
+		redis.call('HDEL', KEYS[1], ARGV[1])
+	end
+end
+local total = 0
+for _, v in ipairs(redis.call('HVALS', KEYS[1])) do
+	total = total + tonumber(v)
+end
+return total
+`)
+
+// dropGateScript 移除用户在 gate 上的全部连接, 返回剩余连接总数, 用户在该 gate 上没有记录时返回 -1
+var dropGateScript = redis.NewScript(`
+local dropped = 0
+local total = 0
+local prefix = ARGV[1] .. '#'
+local fields = redis.call('HGETALL', KEYS[1])
+for i = 1, #fields, 2 do
+	local field = fields[i]
+	if field == ARGV[1] or string.sub(field, 1, #prefix) == prefix then
+		redis.call('HDEL', KEYS[1], field)
+		dropped = dropped + 1
+	else
+		total = total + tonumber(fields[i + 1])
+	end
+end
+if dropped == 0 then
+	return -1
+end
+return total
+`)
+
+// Presence 用户在线状态, LastSeen 为最后一次下线的毫秒时间戳
+type Presence struct {
+	Online   bool  `json:"online"`
+	LastSeen int64 `json:"lastSeen"`
+	Hidden   bool  `json:"-"`
+}
+
+// RegisterGate 记录用户在 gate 上新增一个连接, 并刷新过期时间, 返回登记前后的连接总数
+// connID 非空时同一连接重复登记只算一次; 过期时间用于清理异常退出的 gate 留下的记录
+func RegisterGate(uid, gate, connID string, ttl time.Duration) (before, total int64, err error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	counts, err := registerGateScript.Run(ctx, redisClient(), []string{fmt.Sprintf(presenceKey, uid)}, gate, int64(ttl/time.Second), connID).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(counts) != 2 {
+		return 0, 0, fmt.Errorf("register gate: unexpected reply %v", counts)
+	}
+	return counts[0], counts[1], nil
+}
+
+// UnregisterGate 用户在 gate 上断开一个连接, 返回剩余的连接总数
+func UnregisterGate(uid, gate, connID string) (int64, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	return releaseGateScript.Run(ctx, redisClient(), []string{fmt.Sprintf(presenceKey, uid)}, gate, connID).Int64()
+}
+
+// DropGate 移除用户在失效 gate 上的连接, 返回因此失去全部连接的用户
+func DropGate(gate string, uids ...string) ([]string, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	cmds := make([]*redis.Cmd, len(uids))
+	_, err := redisClient().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, uid := range uids {
+			cmds[i] = dropGateScript.Eval(ctx, pipe, []string{fmt.Sprintf(presenceKey, uid)}, gate)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	offline := make([]string, 0)
+	for i, cmd := range cmds {
+		if total, _ := cmd.Int64(); total == 0 {
+			offline = append(offline, uids[i])
+		}
+	}
+	return offline, nil
+}
+
+func SetLastSeen(uid string, t time.Time) error {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	return redisClient().HSet(ctx, lastSeenKey, uid, t.UnixNano()/int64(time.Millisecond)).Err()
+}
+
+// SetPresenceHidden 隐藏后好友看到的始终是离线且没有最后在线时间
+func SetPresenceHidden(uid string, hidden bool) error {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	if hidden {
+		return redisClient().SAdd(ctx, presenceHiddenKey, uid).Err()
+	}
+	return redisClient().SRem(ctx, presenceHiddenKey, uid).Err()
+}
+
+func IsPresenceHidden(uid string) (bool, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	return redisClient().SIsMember(ctx, presenceHiddenKey, uid).Result()
+}
+
+// GetPresences 返回用户真实的在线状态, 由调用方根据 Hidden 决定对外展示
+func GetPresences(uids ...string) (map[string]*Presence, error) {
+	presences := make(map[string]*Presence, len(uids))
+	if len(uids) == 0 {
+		return presences, nil
+	}
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	online := make([]*redis.IntCmd, len(uids))
+	hidden := make([]*redis.BoolCmd, len(uids))
+	var lastSeen *redis.SliceCmd
+	_, err := redisClient().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, uid := range uids {
+			online[i] = pipe.Exists(ctx, fmt.Sprintf(presenceKey, uid))
+			hidden[i] = pipe.SIsMember(ctx, presenceHiddenKey, uid)
+		}
+		lastSeen = pipe.HMGet(ctx, lastSeenKey, uids...)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	seen := lastSeen.Val()
+	for i, uid := range uids {
+		p := &Presence{Online: online[i].Val() > 0, Hidden: hidden[i].Val()}
+		if i < len(seen) {
+			if v, ok := seen[i].(string); ok {
+				p.LastSeen, _ = strconv.ParseInt(v, 10, 64)
+			}
+		}
+		presences[uid] = p
+	}
+	return presences, nil
+}
+
+// GetUserGates 返回每个用户有连接的 gate, 没有连接的用户不出现在结果中
+func GetUserGates(uids ...string) (map[string][]string, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	cmds := make([]*redis.StringStringMapCmd, len(uids))
+	_, err := redisClient().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, uid := range uids {
+			cmds[i] = pipe.HGetAll(ctx, fmt.Sprintf(presenceKey, uid))
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	gates := make(map[string][]string, len(uids))
+	for i, cmd := range cmds {
+		seen := make(map[string]bool)
+		for field, count := range cmd.Val() {
+			gate := field
+			if j := strings.IndexByte(field, '#'); j >= 0 {
+				gate = field[:j]
+			}
+			if n, _ := strconv.Atoi(count); n > 0 && !seen[gate] {
+				seen[gate] = true
+				gates[uids[i]] = append(gates[uids[i]], gate)
+			}
+		}
+	}
+	return gates, nil
+}
@@ -0,0 +1,85 @@
+package dao
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const CollectionReadCursor = "readCursor"
+
+// ReadCursor 用户在房间内已读到的消息序号
+type ReadCursor struct {
+	UID    string    `json:"uid" bson:"uid"`
+	RoomID string    `json:"roomID" bson:"roomID"`
+	Seq    int64     `json:"seq" bson:"seq"`
+	Time   time.Time `json:"time" bson:"time"`
+}
+
+// AdvanceReadCursor 已读序号只增不减, 返回更新后的序号
+func AdvanceReadCursor(uid, roomID string, seq int64) (int64, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	cursor := &ReadCursor{}
+	advance := func() error {
+		return collection(CollectionReadCursor).FindOneAndUpdate(ctx,
+			bson.M{"uid": uid, "roomID": roomID},
+			bson.M{"$max": bson.M{"seq": seq}, "$set": bson.M{"time": time.Now()}},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		).Decode(cursor)
+	}
+	err := advance()
+	if mongo.IsDuplicateKeyError(err) {
+		// 并发 upsert 时另一方已插入游标, 重试即为更新
+		err = advance()
+	}
+	if err != nil {
+		return 0, err
+	}
+	return cursor.Seq, nil
+}
+
+// AdvanceReadCursors 批量推进已读序号, 同一用户同一房间只取最大的序号
+func AdvanceReadCursors(cursors []*ReadCursor) error {
+	if len(cursors) == 0 {
+		return nil
+	}
+	models := make([]mongo.WriteModel, 0, len(cursors))
+	for _, cursor := range cursors {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"uid": cursor.UID, "roomID": cursor.RoomID}).
+			SetUpdate(bson.M{"$max": bson.M{"seq": cursor.Seq}, "$set": bson.M{"time": cursor.Time}}).
+			SetUpsert(true))
+	}
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	_, err := collection(CollectionReadCursor).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if mongo.IsDuplicateKeyError(err) {
+		// $max 与 $set 可重复执行, 整批重试时冲突的游标已存在, 会按更新处理
+		_, err = collection(CollectionReadCursor).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	}
+	return err
+}
+
+// GetReadSeqs 返回用户在各房间的已读序号, 没有记录的房间为 0
+func GetReadSeqs(uid string, roomIDs []string) (map[string]int64, error) {
+	seqs := make(map[string]int64, len(roomIDs))
+	if len(roomIDs) == 0 {
+		return seqs, nil
+	}
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	cur, err := collection(CollectionReadCursor).Find(ctx, bson.M{"uid": uid, "roomID": bson.M{"$in": roomIDs}})
+	if err != nil {
+		return nil, err
+	}
+	cursors := make([]*ReadCursor, 0)
+	if err = cur.All(ctx, &cursors); err != nil {
+		return nil, err
+	}
+	for _, cursor := range cursors {
+		seqs[cursor.RoomID] = cursor.Seq
+	}
+	return seqs, nil
+}
@@ -0,0 +1,55 @@
+package dao
+
+import (
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+)
+
+const roomSeqKey = "logic:room:seq:%v"
+
+// NextRoomSeq 分配房间内单调递增的消息序号
+func NextRoomSeq(roomID string) (int64, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	return redisClient().Incr(ctx, fmt.Sprintf(roomSeqKey, roomID)).Result()
+}
+
+// GetRoomSeq 房间当前最大序号, 没有消息时为 0
+func GetRoomSeq(roomID string) (int64, error) {
+	seqs, err := GetRoomSeqs([]string{roomID})
+	if err != nil {
+		return 0, err
+	}
+	return seqs[roomID], nil
+}
+
+// GetRoomSeqs 批量获取房间当前最大序号
+func GetRoomSeqs(roomIDs []string) (map[string]int64, error) {
+	seqs := make(map[string]int64, len(roomIDs))
+	if len(roomIDs) == 0 {
+		return seqs, nil
+	}
+	keys := make([]string, 0, len(roomIDs))
+	for _, roomID := range roomIDs {
+		keys = append(keys, fmt.Sprintf(roomSeqKey, roomID))
+	}
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	values, err := redisClient().MGet(ctx, keys...).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	for i, value := range values {
+		raw, ok := value.(string)
+		if !ok {
+			continue
+		}
+		seq, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		seqs[roomIDs[i]] = seq
+	}
+	return seqs, nil
+}
@@ -0,0 +1,34 @@
+package dao
+
+import (
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+const sessionKey = "logic:session:%v"
+
+// SaveSession 记录 Auth 校验通过的 token 对应的用户
+func SaveSession(token, uid string, ttl time.Duration) error {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	return redisClient().Set(ctx, fmt.Sprintf(sessionKey, token), uid, ttl).Err()
+}
+
+// GetSessionUID 未命中时返回空字符串
+func GetSessionUID(token string) (string, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	uid, err := redisClient().Get(ctx, fmt.Sprintf(sessionKey, token)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return uid, err
+}
+
+// DeleteSession 登出或 token 失效时移除缓存, 之后的请求重新校验 token
+func DeleteSession(token string) error {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	return redisClient().Del(ctx, fmt.Sprintf(sessionKey, token)).Err()
+}
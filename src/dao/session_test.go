@@ -0,0 +1,35 @@
+package dao
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionCache(t *testing.T) {
+	mr := useMiniredis(t)
+	uid, err := GetSessionUID("t1")
+	if err != nil || len(uid) > 0 {
+		t.Fatalf("missing session = %q, %v, want empty", uid, err)
+	}
+	if err = SaveSession("t1", "u1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if uid, err = GetSessionUID("t1"); err != nil || uid != "u1" {
+		t.Fatalf("cached session = %q, %v, want u1", uid, err)
+	}
+
+	mr.FastForward(time.Minute)
+	if uid, err = GetSessionUID("t1"); err != nil || len(uid) > 0 {
+		t.Fatalf("expired session = %q, %v, want empty", uid, err)
+	}
+
+	if err = SaveSession("t1", "u1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err = DeleteSession("t1"); err != nil {
+		t.Fatal(err)
+	}
+	if uid, err = GetSessionUID("t1"); err != nil || len(uid) > 0 {
+		t.Fatalf("deleted session = %q, %v, want empty", uid, err)
+	}
+}
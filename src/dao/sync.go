@@ -0,0 +1,114 @@
+package dao
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	syncVersionKey = "logic:sync:version:%v"
+	syncLogKey     = "logic:sync:log:%v"
+)
+
+// appendSyncScript 递增用户版本号并把变更写入按版本排序的日志, 超出上限的旧变更被裁掉
+// 只有日志会过期, 版本号不过期, 否则长时间不活跃后版本从 1 重新开始, 客户端保存的旧版本会跳过新的变更
+// KEYS[1] 版本号 KEYS[2] 变更日志 ARGV[1] 变更内容 ARGV[2] 保留条数 ARGV[3] 过期秒数
+var appendSyncScript = redis.NewScript(`
+local v = redis.call('INCR', KEYS[1])
+redis.call('ZADD', KEYS[2], v, v .. '|' .. ARGV[1])
+redis.call('ZREMRANGEBYRANK', KEYS[2], 0, -tonumber(ARGV[2]) - 1)
+redis.call('EXPIRE', KEYS[2], ARGV[3])
+return v
+`)
+
+// SyncChange 用户的一条增量变更
+type SyncChange struct {
+	Version int64           `json:"version"`
+	Type    string          `json:"type"`
+	Data    json.RawMessage `json:"data"`
+	Time    int64           `json:"time"`
+}
+
+type syncEntry struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+	Time int64           `json:"time"`
+}
+
+// AppendSyncChanges 为每个用户追加同一条变更, 返回各用户分配到的版本
+func AppendSyncChanges(uids []string, changeType string, data interface{}, maxChanges int, ttl time.Duration) (map[string]*SyncChange, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	entry := &syncEntry{Type: changeType, Data: raw, Time: time.Now().UnixNano() / int64(time.Millisecond)}
+	member, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	cmds := make(map[string]*redis.Cmd, len(uids))
+	_, err = redisClient().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, uid := range uids {
+			keys := []string{fmt.Sprintf(syncVersionKey, uid), fmt.Sprintf(syncLogKey, uid)}
+			// pipeline 中的 EVALSHA 无法在 NOSCRIPT 时退回 EVAL, 直接发送脚本
+			cmds[uid] = appendSyncScript.Eval(ctx, pipe, keys, string(member), maxChanges, int64(ttl/time.Second))
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	changes := make(map[string]*SyncChange, len(uids))
+	for uid, cmd := range cmds {
+		version, err := cmd.Int64()
+		if err != nil {
+			return nil, err
+		}
+		changes[uid] = &SyncChange{Version: version, Type: entry.Type, Data: entry.Data, Time: entry.Time}
+	}
+	return changes, nil
+}
+
+// GetSyncVersion 用户当前版本, 从未变更过为 0
+func GetSyncVersion(uid string) (int64, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	version, err := redisClient().Get(ctx, fmt.Sprintf(syncVersionKey, uid)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return version, err
+}
+
+// GetSyncChangesSince 返回版本号大于 version 的变更, 按版本升序
+func GetSyncChangesSince(uid string, version int64) ([]*SyncChange, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	members, err := redisClient().ZRangeByScoreWithScores(ctx, fmt.Sprintf(syncLogKey, uid), &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(version, 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]*SyncChange, 0, len(members))
+	for _, z := range members {
+		raw, _ := z.Member.(string)
+		if i := strings.IndexByte(raw, '|'); i >= 0 {
+			raw = raw[i+1:]
+		}
+		entry := &syncEntry{}
+		if err = json.Unmarshal([]byte(raw), entry); err != nil {
+			return nil, err
+		}
+		changes = append(changes, &SyncChange{Version: int64(z.Score), Type: entry.Type, Data: entry.Data, Time: entry.Time})
+	}
+	return changes, nil
+}
@@ -0,0 +1,61 @@
+package dao
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAppendSyncChanges(t *testing.T) {
+	// 每个用例使用新的 miniredis, 脚本从未加载过
+	mr := useMiniredis(t)
+	const maxChanges, ttl = 3, time.Hour
+	for want := int64(1); want <= 5; want++ {
+		changes, err := AppendSyncChanges([]string{"u1", "u2"}, "friendAdded", map[string]int64{"n": want}, maxChanges, ttl)
+		if err != nil {
+			t.Fatalf("append %v err: %v", want, err)
+		}
+		for _, uid := range []string{"u1", "u2"} {
+			if changes[uid] == nil || changes[uid].Version != want {
+				t.Fatalf("append %v version for %v = %+v", want, uid, changes[uid])
+			}
+		}
+	}
+	if version, err := GetSyncVersion("u1"); err != nil || version != 5 {
+		t.Fatalf("version = %v, %v, want 5", version, err)
+	}
+
+	// 只保留最近 maxChanges 条
+	changes, err := GetSyncChangesSince("u1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := make([]int64, 0, len(changes))
+	for _, change := range changes {
+		versions = append(versions, change.Version)
+	}
+	if len(versions) != maxChanges || versions[0] != 3 || versions[2] != 5 {
+		t.Fatalf("kept versions %v, want [3 4 5]", versions)
+	}
+	if changes[0].Type != "friendAdded" || string(changes[0].Data) != `{"n":3}` {
+		t.Fatalf("change = %+v", changes[0])
+	}
+	if changes, err = GetSyncChangesSince("u1", 4); err != nil || len(changes) != 1 || changes[0].Version != 5 {
+		t.Fatalf("changes since 4 = %v, %v", changes, err)
+	}
+
+	// 日志过期后版本号继续递增, 不会从 1 重新开始
+	if ttl := mr.TTL("logic:sync:version:u1"); ttl != 0 {
+		t.Fatalf("version key ttl = %v, want none", ttl)
+	}
+	mr.FastForward(ttl)
+	if changes, err = GetSyncChangesSince("u1", 0); err != nil || len(changes) != 0 {
+		t.Fatalf("changes after log expired = %v, %v", changes, err)
+	}
+	next, err := AppendSyncChanges([]string{"u1"}, "friendDeleted", nil, maxChanges, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next["u1"].Version != 6 {
+		t.Fatalf("version after log expired = %v, want 6", next["u1"].Version)
+	}
+}
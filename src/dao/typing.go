@@ -0,0 +1,48 @@
+package dao
+
+import (
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+const (
+	// typingKey 限流标记, 存在期间不再转发开始输入
+	typingKey = "logic:typing:%v:%v"
+	// typingActiveKey 已转发开始输入且对方仍在展示提示, 只有存在时才转发停止输入
+	typingActiveKey = "logic:typing:active:%v:%v"
+)
+
+// claimTypingScript KEYS[1] 限流标记 KEYS[2] 展示标记 ARGV[1] 限流毫秒数 ARGV[2] 展示毫秒数
+var claimTypingScript = redis.NewScript(`
+if not redis.call('SET', KEYS[1], 1, 'NX', 'PX', ARGV[1]) then
+	return 0
+end
+redis.call('SET', KEYS[2], 1, 'PX', ARGV[2])
+return 1
+`)
+
+// ClaimTyping 同一用户在同一房间 interval 内只转发一次开始输入, 返回本次是否可以转发
+// 转发后 expire 内的停止输入可以转发一次
+func ClaimTyping(uid, roomID string, interval, expire time.Duration) (bool, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	keys := []string{fmt.Sprintf(typingKey, roomID, uid), fmt.Sprintf(typingActiveKey, roomID, uid)}
+	return claimTypingScript.Run(ctx, redisClient(), keys, interval.Milliseconds(), expire.Milliseconds()).Bool()
+}
+
+// ReleaseTyping 停止输入后允许下一次开始输入立即转发, 返回之前转发的开始输入是否仍在展示
+func ReleaseTyping(uid, roomID string) (bool, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	var active *redis.IntCmd
+	_, err := redisClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		active = pipe.Del(ctx, fmt.Sprintf(typingActiveKey, roomID, uid))
+		pipe.Del(ctx, fmt.Sprintf(typingKey, roomID, uid))
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return active.Val() > 0, nil
+}
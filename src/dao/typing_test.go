@@ -0,0 +1,54 @@
+package dao
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTypingThrottle(t *testing.T) {
+	mr := useMiniredis(t)
+	const interval, expire = 3 * time.Second, 6 * time.Second
+	claim := func() bool {
+		t.Helper()
+		ok, err := ClaimTyping("u1", "room", interval, expire)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	release := func() bool {
+		t.Helper()
+		ok, err := ReleaseTyping("u1", "room")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		action  func() bool
+		want    bool
+	}{
+		{"stop before any start is not relayed", 0, release, false},
+		{"first start is relayed", 0, claim, true},
+		{"start within interval is throttled", time.Second, claim, false},
+		{"stop after relayed start is relayed", 0, release, true},
+		{"second stop is not relayed", 0, release, false},
+		{"start right after stop is relayed", 0, claim, true},
+		{"start after interval is relayed", interval, claim, true},
+		{"stop after indicator expired is not relayed", expire, release, false},
+	}
+	for _, step := range steps {
+		mr.FastForward(step.advance)
+		if got := step.action(); got != step.want {
+			t.Fatalf("%v: got %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	ok, err := ClaimTyping("u2", "room", interval, expire)
+	if err != nil || !ok {
+		t.Fatalf("another sender is throttled separately, got %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,84 @@
+package dao
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const CollectionUserBlock = "userBlock"
+
+// UserBlock UID 屏蔽了 Target
+type UserBlock struct {
+	UID    string    `json:"uid" bson:"uid"`
+	Target string    `json:"target" bson:"target"`
+	Time   time.Time `json:"time" bson:"time"`
+}
+
+func AddUserBlock(uid, target string) error {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	_, err := collection(CollectionUserBlock).ReplaceOne(ctx,
+		bson.M{"uid": uid, "target": target},
+		&UserBlock{UID: uid, Target: target, Time: time.Now()},
+		options.Replace().SetUpsert(true))
+	return err
+}
+
+func DeleteUserBlock(uid, target string) error {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	_, err := collection(CollectionUserBlock).DeleteOne(ctx, bson.M{"uid": uid, "target": target})
+	return err
+}
+
+// IsBlocking uid 是否屏蔽了 target
+func IsBlocking(uid, target string) (bool, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	n, err := collection(CollectionUserBlock).CountDocuments(ctx, bson.M{"uid": uid, "target": target}, options.Count().SetLimit(1))
+	return n > 0, err
+}
+
+// GetBlockedUIDs 返回 uid 屏蔽的用户
+func GetBlockedUIDs(uid string) ([]string, error) {
+	return findBlockField(bson.M{"uid": uid}, "target")
+}
+
+// GetBlockerUIDs 返回屏蔽了 uid 的用户
+func GetBlockerUIDs(uid string) ([]string, error) {
+	return findBlockField(bson.M{"target": uid}, "uid")
+}
+
+func findBlockField(filter bson.M, field string) ([]string, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	cursor, err := collection(CollectionUserBlock).Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	blocks := make([]*UserBlock, 0)
+	if err = cursor.All(ctx, &blocks); err != nil {
+		return nil, err
+	}
+	uids := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		if field == "uid" {
+			uids = append(uids, block.UID)
+		} else {
+			uids = append(uids, block.Target)
+		}
+	}
+	return uids, nil
+}
+
+// IsBlockedBetween 任意一方屏蔽了另一方
+func IsBlockedBetween(a, b string) (bool, error) {
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	n, err := collection(CollectionUserBlock).CountDocuments(ctx, bson.M{"$or": bson.A{
+		bson.M{"uid": a, "target": b},
+		bson.M{"uid": b, "target": a},
+	}})
+	return n > 0, err
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"framework/cfgargs"
 	"framework/logger"
 	"log"
 	"logic/app"
+	"logic/conf"
 	"os"
 	"os/signal"
+	"syscall"
+	"time"
 )
 
 var (
@@ -28,14 +32,24 @@ func main() {
 		log.Fatal(err)
 	}
 	srvConfig.Print()
+	logicConfig, err := conf.InitLogicCfg(conf.ConfigPath())
+	if err != nil {
+		log.Fatal(err)
+	}
 	logger.InitLogger(srvConfig)
-	app.GetApp().Init(srvConfig)
+	app.GetApp().Init(srvConfig, logicConfig)
 	logger.Info("App logic started...")
 
-	quit := make(chan os.Signal)
-	signal.Notify(quit, os.Interrupt)
-	<-quit
-	logger.Fatal("Server force shutdown...")
-	close(quit)
-	os.Exit(1)
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	sig := <-quit
+	logger.Info("App logic receive signal %v, shutting down...", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(logicConfig.ShutdownTimeout)*time.Second)
+	defer cancel()
+	if err := app.GetApp().Shutdown(ctx); err != nil {
+		logger.Error("Server force shutdown: %v", err)
+		os.Exit(1)
+	}
+	logger.Info("App logic exited")
 }
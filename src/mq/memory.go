@@ -0,0 +1,34 @@
+package mq
+
+import (
+	"logic/dao"
+)
+
+// MemoryQueue 内存队列, 进程退出即丢失, 仅用于开发环境
+type MemoryQueue struct {
+	buf *buffer
+}
+
+// NewMemoryQueue capacity 大于 0 时超出容量的 Produce 返回 ErrQueueFull
+func NewMemoryQueue(capacity int) *MemoryQueue {
+	return &MemoryQueue{
+		buf: newBuffer(capacity),
+	}
+}
+
+func (q *MemoryQueue) Produce(message *dao.ChatMessage) error {
+	return q.buf.push(&Message{ID: newMessageID(), Payload: message})
+}
+
+func (q *MemoryQueue) Messages() <-chan *Message {
+	return q.buf.out
+}
+
+func (q *MemoryQueue) Ack(id string) error {
+	return nil
+}
+
+func (q *MemoryQueue) Close() error {
+	q.buf.close()
+	return nil
+}
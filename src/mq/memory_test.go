@@ -0,0 +1,142 @@
+package mq
+
+import (
+	"logic/dao"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func chatMessage(id string) *dao.ChatMessage {
+	m := &dao.ChatMessage{}
+	m.MessageID = id
+	return m
+}
+
+// receive 读取 n 条消息, 超时视为失败
+func receive(t *testing.T, q MessageQueue, n int) []*Message {
+	t.Helper()
+	messages := make([]*Message, 0, n)
+	for len(messages) < n {
+		select {
+		case m, ok := <-q.Messages():
+			if !ok {
+				t.Fatalf("channel closed after %v messages, want %v", len(messages), n)
+			}
+			messages = append(messages, m)
+		case <-time.After(time.Second):
+			t.Fatalf("received %v messages, want %v", len(messages), n)
+		}
+	}
+	return messages
+}
+
+func payloadIDs(messages []*Message) []string {
+	ids := make([]string, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.Payload.MessageID)
+	}
+	return ids
+}
+
+func assertClosed(t *testing.T, q MessageQueue) {
+	t.Helper()
+	select {
+	case m, ok := <-q.Messages():
+		if ok {
+			t.Fatalf("unexpected message %v after drain", m.Payload.MessageID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed after drain")
+	}
+}
+
+func TestMemoryQueueOrderAndClose(t *testing.T) {
+	q := NewMemoryQueue(0)
+	for _, id := range []string{"m1", "m2", "m3"} {
+		if err := q.Produce(chatMessage(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first := receive(t, q, 1)
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Produce(chatMessage("m4")); err != ErrQueueClosed {
+		t.Fatalf("produce after close err = %v, want ErrQueueClosed", err)
+	}
+	// 关闭前已入队的消息仍会投递
+	rest := receive(t, q, 2)
+	got := payloadIDs(append(first, rest...))
+	for i, want := range []string{"m1", "m2", "m3"} {
+		if got[i] != want {
+			t.Fatalf("delivered %v, want m1 m2 m3", got)
+		}
+	}
+	assertClosed(t, q)
+}
+
+// waitLen 等待 dispatch 协程把缓冲搬运到 n 条
+func waitLen(t *testing.T, q *MemoryQueue, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for q.buf.len() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("buffer len = %v, want %v", q.buf.len(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMemoryQueueCapacity(t *testing.T) {
+	q := NewMemoryQueue(2)
+	defer q.Close()
+	if err := q.Produce(chatMessage("m1")); err != nil {
+		t.Fatal(err)
+	}
+	// 没有消费者时 dispatch 取走一条后阻塞, 缓冲中还能再容纳 capacity 条
+	waitLen(t, q, 0)
+	for _, id := range []string{"m2", "m3"} {
+		if err := q.Produce(chatMessage(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Produce(chatMessage("m4")); err != ErrQueueFull {
+		t.Fatalf("produce over capacity err = %v, want ErrQueueFull", err)
+	}
+	if got := payloadIDs(receive(t, q, 1)); got[0] != "m1" {
+		t.Fatalf("received %v, want m1", got)
+	}
+	waitLen(t, q, 1)
+	if err := q.Produce(chatMessage("m4")); err != nil {
+		t.Fatalf("produce after consume err: %v", err)
+	}
+}
+
+func TestMemoryQueueCapacityConcurrent(t *testing.T) {
+	const capacity, producers = 10, 50
+	q := NewMemoryQueue(capacity)
+	defer q.Close()
+	var (
+		wg       sync.WaitGroup
+		accepted int64
+	)
+	for i := 0; i < producers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if q.Produce(chatMessage("m")) == nil {
+				atomic.AddInt64(&accepted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	// dispatch 协程最多额外取走一条
+	if accepted > capacity+1 {
+		t.Fatalf("accepted %v messages, capacity %v", accepted, capacity)
+	}
+	if n := q.buf.len(); n > capacity {
+		t.Fatalf("buffer holds %v messages, capacity %v", n, capacity)
+	}
+}
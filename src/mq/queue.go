@@ -0,0 +1,131 @@
+// Package mq
+// @Title  queue.go
+// @Description  聊天消息持久化队列, 支持内存/wal/redis stream 三种实现
+// @Author  peanut996
+package mq
+
+import (
+	"errors"
+	"fmt"
+	"logic/conf"
+	"logic/dao"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrQueueClosed = errors.New("message queue closed")
+	ErrQueueFull   = errors.New("message queue full")
+)
+
+// Message 队列中的一条消息, ID 用于 Ack
+type Message struct {
+	ID      string           `json:"id"`
+	Payload *dao.ChatMessage `json:"payload"`
+}
+
+// MessageQueue 聊天消息队列
+// Messages 返回的 channel 会先投递上次未 Ack 的消息, Close 后该 channel 在排空时关闭
+type MessageQueue interface {
+	Produce(message *dao.ChatMessage) error
+	Messages() <-chan *Message
+	Ack(id string) error
+	Close() error
+}
+
+// NewMessageQueue 根据配置创建队列
+func NewMessageQueue(cfg *conf.QueueConfig) (MessageQueue, error) {
+	switch cfg.Mode {
+	case conf.QueueModeMemory, "":
+		return NewMemoryQueue(cfg.Capacity), nil
+	case conf.QueueModeWAL:
+		return NewWALQueue(cfg.Dir, cfg.Sync)
+	case conf.QueueModeRedis:
+		consumer, err := consumerName(cfg.Consumer)
+		if err != nil {
+			return nil, err
+		}
+		return NewRedisQueue(cfg.Stream, cfg.Group, consumer)
+	}
+	return nil, fmt.Errorf("unknown queue mode: %v", cfg.Mode)
+}
+
+// consumerName 未配置时使用主机名, 各节点在消费组内互不相同且重启后不变, 才能只重放自己未 Ack 的消息
+func consumerName(configured string) (string, error) {
+	if len(configured) > 0 {
+		return configured, nil
+	}
+	return os.Hostname()
+}
+
+var idCounter uint64
+
+func newMessageID() string {
+	return fmt.Sprintf("%d-%d", time.Now().UnixNano(), atomic.AddUint64(&idCounter, 1))
+}
+
+// buffer FIFO, 由 dispatch 协程搬运到 out channel, 使 Produce 不会阻塞在消费者上
+// capacity 大于 0 时缓冲中最多保留 capacity 条, 超出时 push 返回 ErrQueueFull
+type buffer struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	items    []*Message
+	capacity int
+	closed   bool
+	out      chan *Message
+}
+
+func newBuffer(capacity int) *buffer {
+	b := &buffer{out: make(chan *Message), capacity: capacity}
+	b.cond = sync.NewCond(&b.mu)
+	go b.dispatch()
+	return b
+}
+
+func (b *buffer) push(m *Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrQueueClosed
+	}
+	if b.capacity > 0 && len(b.items) >= b.capacity {
+		return ErrQueueFull
+	}
+	b.items = append(b.items, m)
+	b.cond.Signal()
+	return nil
+}
+
+func (b *buffer) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.items)
+}
+
+func (b *buffer) close() {
+	b.mu.Lock()
+	b.closed = true
+	b.cond.Signal()
+	b.mu.Unlock()
+}
+
+func (b *buffer) dispatch() {
+	for {
+		b.mu.Lock()
+		for len(b.items) == 0 && !b.closed {
+			b.cond.Wait()
+		}
+		if len(b.items) == 0 && b.closed {
+			b.mu.Unlock()
+			close(b.out)
+			return
+		}
+		m := b.items[0]
+		b.items[0] = nil
+		b.items = b.items[1:]
+		b.mu.Unlock()
+		b.out <- m
+	}
+}
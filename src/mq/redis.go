@@ -0,0 +1,142 @@
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"framework/db"
+	"framework/logger"
+	"github.com/go-redis/redis/v8"
+	"logic/dao"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	redisPayloadField = "payload"
+	redisReadCount    = 100
+	redisReadBlock    = 2 * time.Second
+)
+
+// RedisQueue 基于 redis stream 消费组的队列, 使用 App.Init 初始化的 redis 客户端
+// 启动时先读取本消费者 pending 列表中未 Ack 的消息, 再读取新消息
+type RedisQueue struct {
+	client   *redis.Client
+	stream   string
+	group    string
+	consumer string
+	buf      *buffer
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func NewRedisQueue(stream, group, consumer string) (*RedisQueue, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &RedisQueue{
+		client:   db.GetLastRedisClient(),
+		stream:   stream,
+		group:    group,
+		consumer: consumer,
+		buf:      newBuffer(0),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	err := q.client.XGroupCreateMkStream(ctx, stream, group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		cancel()
+		return nil, err
+	}
+	q.wg.Add(1)
+	go q.read()
+	return q, nil
+}
+
+func (q *RedisQueue) read() {
+	defer q.wg.Done()
+	defer q.buf.close()
+	// 先从 "0" 开始把已投递但未 Ack 的消息读一遍, 每次从上一批最后一条之后继续
+	// 读完后切换为 ">" 只读取新消息, 重放中失败的消息留在 pending 列表等待下次启动
+	start := "0"
+	replaying := true
+	for q.ctx.Err() == nil {
+		streams, err := q.client.XReadGroup(q.ctx, &redis.XReadGroupArgs{
+			Group:    q.group,
+			Consumer: q.consumer,
+			Streams:  []string{q.stream, start},
+			Count:    redisReadCount,
+			Block:    redisReadBlock,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			if q.ctx.Err() != nil {
+				return
+			}
+			logger.Error("MQ.Redis XReadGroup err: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		received := 0
+		for _, stream := range streams {
+			for _, xm := range stream.Messages {
+				received++
+				if replaying {
+					start = xm.ID
+				}
+				m, err := decodeRedisMessage(xm)
+				if err != nil {
+					logger.Error("MQ.Redis drop broken message %v: %v", xm.ID, err)
+					q.client.XAck(q.ctx, q.stream, q.group, xm.ID)
+					continue
+				}
+				if err = q.buf.push(m); err != nil {
+					return
+				}
+			}
+		}
+		if replaying && received == 0 {
+			replaying = false
+			start = ">"
+		}
+	}
+}
+
+func decodeRedisMessage(xm redis.XMessage) (*Message, error) {
+	raw, _ := xm.Values[redisPayloadField].(string)
+	payload := &dao.ChatMessage{}
+	if err := json.Unmarshal([]byte(raw), payload); err != nil {
+		return nil, err
+	}
+	return &Message{ID: xm.ID, Payload: payload}, nil
+}
+
+func (q *RedisQueue) Produce(message *dao.ChatMessage) error {
+	if q.ctx.Err() != nil {
+		return ErrQueueClosed
+	}
+	raw, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return q.client.XAdd(q.ctx, &redis.XAddArgs{
+		Stream: q.stream,
+		Values: map[string]interface{}{redisPayloadField: string(raw)},
+	}).Err()
+}
+
+func (q *RedisQueue) Messages() <-chan *Message {
+	return q.buf.out
+}
+
+func (q *RedisQueue) Ack(id string) error {
+	return q.client.XAck(context.Background(), q.stream, q.group, id).Err()
+}
+
+// Close 停止读取新消息, 已读出的消息仍会被投递, 未 Ack 的消息留在 pending 列表等待下次启动重放
+func (q *RedisQueue) Close() error {
+	q.cancel()
+	q.wg.Wait()
+	return nil
+}
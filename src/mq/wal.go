@@ -0,0 +1,261 @@
+package mq
+
+import (
+	"bufio"
+	"encoding/json"
+	"framework/logger"
+	"logic/dao"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	walFileName = "chat.wal"
+	walOpPut    = "put"
+	walOpAck    = "ack"
+	// walCompactThreshold 记录数超过该值且超过未 Ack 消息数两倍时, 把 wal 重写为只含未 Ack 的消息
+	walCompactThreshold = 1024
+)
+
+type walRecord struct {
+	Op      string           `json:"op"`
+	ID      string           `json:"id"`
+	Payload *dao.ChatMessage `json:"payload,omitempty"`
+}
+
+type walPending struct {
+	order   uint64
+	message *Message
+}
+
+// WALQueue 基于本地预写日志的队列, 消息写盘后才投递, 未 Ack 的消息在重启后重放
+type WALQueue struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	sync bool
+	// pending 未 Ack 的消息及其写入顺序, 压缩时按该顺序重写
+	pending map[string]*walPending
+	next    uint64
+	records int
+	closed  bool
+	buf     *buffer
+}
+
+func NewWALQueue(dir string, fsync bool) (*WALQueue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	q := &WALQueue{
+		path:    filepath.Join(dir, walFileName),
+		sync:    fsync,
+		pending: make(map[string]*walPending),
+		buf:     newBuffer(0),
+	}
+	unacked, err := q.recover()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(q.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	q.file = file
+	for _, m := range unacked {
+		q.track(m)
+		_ = q.buf.push(m)
+	}
+	q.records = len(unacked)
+	if len(unacked) > 0 {
+		logger.Info("MQ.WAL replay %v unacked messages from %v", len(unacked), q.path)
+	}
+	return q, nil
+}
+
+// recover 读取 wal 得到未 Ack 的消息, 并将其重写为只含这些消息的新文件
+func (q *WALQueue) recover() ([]*Message, error) {
+	file, err := os.Open(q.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	order := make([]string, 0)
+	puts := make(map[string]*dao.ChatMessage)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		r := &walRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			// 最后一行可能因崩溃写了一半, 忽略
+			logger.Warn("MQ.WAL skip broken record: %v", err)
+			continue
+		}
+		switch r.Op {
+		case walOpPut:
+			order = append(order, r.ID)
+			puts[r.ID] = r.Payload
+		case walOpAck:
+			delete(puts, r.ID)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	unacked := make([]*Message, 0, len(puts))
+	for _, id := range order {
+		if payload, ok := puts[id]; ok {
+			unacked = append(unacked, &Message{ID: id, Payload: payload})
+		}
+	}
+	return unacked, q.rewrite(unacked)
+}
+
+func (q *WALQueue) rewrite(messages []*Message) error {
+	tmp := q.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for _, m := range messages {
+		if err = writeRecord(w, &walRecord{Op: walOpPut, ID: m.ID, Payload: m.Payload}); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path)
+}
+
+func writeRecord(w interface{ Write([]byte) (int, error) }, r *walRecord) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+func (q *WALQueue) append(r *walRecord) error {
+	if err := writeRecord(q.file, r); err != nil {
+		return err
+	}
+	q.records++
+	if q.sync {
+		return q.file.Sync()
+	}
+	return nil
+}
+
+func (q *WALQueue) Produce(message *dao.ChatMessage) error {
+	m := &Message{ID: newMessageID(), Payload: message}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	if err := q.append(&walRecord{Op: walOpPut, ID: m.ID, Payload: message}); err != nil {
+		return err
+	}
+	q.track(m)
+	return q.buf.push(m)
+}
+
+func (q *WALQueue) Messages() <-chan *Message {
+	return q.buf.out
+}
+
+func (q *WALQueue) Ack(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.pending[id]; !ok {
+		return nil
+	}
+	if q.file == nil {
+		return ErrQueueClosed
+	}
+	if err := q.append(&walRecord{Op: walOpAck, ID: id}); err != nil {
+		return err
+	}
+	delete(q.pending, id)
+	if len(q.pending) == 0 && q.closed {
+		return q.release()
+	}
+	if q.records > walCompactThreshold && q.records > 2*len(q.pending) {
+		if err := q.compact(); err != nil {
+			logger.Warn("MQ.WAL compact failed: %v", err)
+		}
+	}
+	return nil
+}
+
+func (q *WALQueue) track(m *Message) {
+	q.next++
+	q.pending[m.ID] = &walPending{order: q.next, message: m}
+}
+
+// compact 把 wal 重写为只含未 Ack 的消息, 重写失败时继续追加原文件
+func (q *WALQueue) compact() error {
+	entries := make([]*walPending, 0, len(q.pending))
+	for _, entry := range q.pending {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].order < entries[j].order })
+	messages := make([]*Message, 0, len(entries))
+	for _, entry := range entries {
+		messages = append(messages, entry.message)
+	}
+	if err := q.rewrite(messages); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(q.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	// 旧文件已被替换, 之后的追加写入新文件
+	q.file.Close()
+	q.file = file
+	q.records = len(messages)
+	return nil
+}
+
+// Close 停止接收新消息, 已入队的消息仍会被投递, 全部 Ack 后关闭 wal 文件
+func (q *WALQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	q.buf.close()
+	if len(q.pending) == 0 {
+		return q.release()
+	}
+	return nil
+}
+
+func (q *WALQueue) release() error {
+	if q.file == nil {
+		return nil
+	}
+	err := q.file.Close()
+	q.file = nil
+	return err
+}
@@ -0,0 +1,124 @@
+package mq
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWALQueueReplaysUnacked(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewWALQueue(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"m1", "m2", "m3"} {
+		if err = q.Produce(chatMessage(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	messages := receive(t, q, 3)
+	if err = q.Ack(messages[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	// 重复 Ack 与未知 ID 都忽略
+	if err = q.Ack(messages[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Ack("unknown"); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assertClosed(t, q)
+
+	// 模拟进程退出后重启, 未 Ack 的消息按原顺序以原 ID 重放
+	replayed, err := NewWALQueue(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := receive(t, replayed, 2)
+	if ids := payloadIDs(got); !reflect.DeepEqual(ids, []string{"m1", "m3"}) {
+		t.Fatalf("replayed %v, want [m1 m3]", ids)
+	}
+	if got[0].ID != messages[0].ID || got[1].ID != messages[2].ID {
+		t.Fatalf("replayed ids %v %v, want %v %v", got[0].ID, got[1].ID, messages[0].ID, messages[2].ID)
+	}
+	for _, m := range got {
+		if err = replayed.Ack(m.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = replayed.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assertClosed(t, replayed)
+
+	empty, err := NewWALQueue(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = empty.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assertClosed(t, empty)
+}
+
+func TestWALQueueProduceAfterClose(t *testing.T) {
+	q, err := NewWALQueue(t.TempDir(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Produce(chatMessage("m1")); err != ErrQueueClosed {
+		t.Fatalf("produce after close err = %v, want ErrQueueClosed", err)
+	}
+}
+
+func TestWALQueueCompactsUnderLoad(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewWALQueue(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := walCompactThreshold + 10
+	for i := 0; i < total; i++ {
+		if err = q.Produce(chatMessage(fmt.Sprintf("m%v", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	messages := receive(t, q, total)
+	// 保留第一条与最后两条始终未 Ack, wal 永远不会为空
+	for _, m := range messages[1 : total-2] {
+		if err = q.Ack(m.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, walFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(raw, []byte("\n")); lines > walCompactThreshold+1 {
+		t.Fatalf("wal has %v records after acking %v of %v messages", lines, total-3, total)
+	}
+	if err = q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assertClosed(t, q)
+
+	replayed, err := NewWALQueue(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayed.Close()
+	want := []string{"m0", fmt.Sprintf("m%v", total-2), fmt.Sprintf("m%v", total-1)}
+	if ids := payloadIDs(receive(t, replayed, len(want))); !reflect.DeepEqual(ids, want) {
+		t.Fatalf("replayed %v, want %v", ids, want)
+	}
+}
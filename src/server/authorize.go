@@ -0,0 +1,427 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"framework/api"
+	"framework/api/model"
+	"framework/db"
+	"framework/logger"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"logic/dao"
+	"net/http"
+	"time"
+)
+
+const (
+	// TokenHeader gate 转发请求时携带的用户 token
+	TokenHeader = "X-Token"
+
+	contextKeyCaller = "logic.caller"
+)
+
+// authRule 校验调用者对本次请求的权限
+type authRule func(c *gin.Context, caller string) error
+
+// authorize 从 token 解析调用者并执行 rule, rule 为 nil 或未开启鉴权时不鉴权
+func (s *Server) authorize(rule authRule, handler gin.HandlerFunc) gin.HandlerFunc {
+	if rule == nil || !s.logicCfg.Auth.Enabled {
+		return handler
+	}
+	return s.enforce(rule, handler)
+}
+
+// enforce 总是解析调用者并执行 rule, 不受 auth.enabled 影响
+func (s *Server) enforce(rule authRule, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller, err := s.resolveCaller(c)
+		if err != nil {
+			logger.Error("Logic.authorize resolve caller err: %v", err)
+			c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+			return
+		}
+		if err = rule(c, caller); err != nil {
+			logger.Warn("Logic.authorize %v denied for %v: %v", c.FullPath(), caller, err)
+			c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+			return
+		}
+		c.Set(contextKeyCaller, caller)
+		handler(c)
+	}
+}
+
+// authorizeGate 校验 gate 密钥而不是用户 token, 未开启鉴权时不校验
+// connect 与 disconnect 由 gate 代用户上报, 用户自己的 token 不能证明连接确实存在
+func (s *Server) authorizeGate(handler gin.HandlerFunc) gin.HandlerFunc {
+	if !s.logicCfg.Auth.Enabled {
+		return handler
+	}
+	return func(c *gin.Context) {
+		if err := s.gates.authenticate(c); err != nil {
+			logger.Warn("Logic.authorize %v denied for gate %q: %v", c.FullPath(), c.GetHeader(GateHeader), err)
+			c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+			return
+		}
+		handler(c)
+	}
+}
+
+// Caller 返回鉴权后的调用者 UID, 未鉴权的路由返回空字符串
+func Caller(c *gin.Context) string {
+	return c.GetString(contextKeyCaller)
+}
+
+// requestToken 优先取 X-Token 请求头, 其次取请求体中的 token 字段
+func requestToken(c *gin.Context) (string, error) {
+	token := c.GetHeader(TokenHeader)
+	if len(token) > 0 {
+		return token, nil
+	}
+	body := &struct {
+		Token string `json:"token"`
+	}{}
+	if err := peekJSON(c, body); err != nil {
+		return "", err
+	}
+	return body.Token, nil
+}
+
+func (s *Server) resolveCaller(c *gin.Context) (string, error) {
+	token, err := requestToken(c)
+	if err != nil {
+		return "", err
+	}
+	if len(token) == 0 {
+		return "", ErrUnauthenticated
+	}
+	uid, err := dao.GetSessionUID(token)
+	if err != nil {
+		logger.Error("Logic.resolveCaller get session err: %v", err)
+	}
+	if len(uid) > 0 {
+		return uid, nil
+	}
+	user, err := api.CheckToken(token)
+	if err != nil {
+		if db.IsNotExistError(err) {
+			return "", ErrUnauthenticated
+		}
+		return "", err
+	}
+	s.saveSession(token, user.UID)
+	return user.UID, nil
+}
+
+func (s *Server) saveSession(token, uid string) {
+	ttl := time.Duration(s.logicCfg.Auth.SessionTTL) * time.Second
+	if ttl <= 0 {
+		return
+	}
+	if err := dao.SaveSession(token, uid, ttl); err != nil {
+		logger.Error("Logic.saveSession err: %v", err)
+	}
+}
+
+func (s *Server) deleteSession(token string) {
+	if err := dao.DeleteSession(token); err != nil {
+		logger.Error("Logic.deleteSession err: %v", err)
+	}
+}
+
+// peekJSON 解析请求体但不消费, handler 仍可再次 BindJSON
+func peekJSON(c *gin.Context, obj interface{}) error {
+	raw, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		return err
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(raw))
+	if len(raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw, obj)
+}
+
+func (s *Server) isGroupMember(uid, groupID string) (bool, error) {
+	uids, err := model.GetUserIDsByGroupID(groupID)
+	if err != nil {
+		return false, err
+	}
+	return containsString(uids, uid), nil
+}
+
+func (s *Server) isRoomMember(uid, roomID string) (bool, error) {
+	room, err := model.GetRoomByID(roomID)
+	if err != nil {
+		return false, err
+	}
+	if room.OneToOne {
+		uids, err := model.GetFriendsByRoomID(room.RoomID)
+		if err != nil {
+			return false, err
+		}
+		return containsString(uids, uid), nil
+	}
+	return s.isGroupMember(uid, roomID)
+}
+
+func containsString(list []string, target string) bool {
+	for _, item := range list {
+		if item == target {
+			return true
+		}
+	}
+	return false
+}
+
+func requireSelf(caller, uid string) error {
+	if caller != uid {
+		return ErrNotSelf
+	}
+	return nil
+}
+
+// requireOperator 请求可以不携带操作者, 携带时须与调用者一致
+func requireOperator(caller, uid string) error {
+	if len(uid) == 0 {
+		return nil
+	}
+	return requireSelf(caller, uid)
+}
+
+// operator 优先取鉴权后的调用者, 未开启鉴权时取请求中的操作者
+func operator(c *gin.Context, uid string) string {
+	if caller := Caller(c); len(caller) > 0 {
+		return caller
+	}
+	return uid
+}
+
+// adminRule 仅允许配置中的管理员调用, 通过 adminRoute 挂载, 未开启鉴权时同样生效
+func (s *Server) adminRule(c *gin.Context, caller string) error {
+	if !containsString(s.logicCfg.Auth.Admins, caller) {
+		return ErrNotAdmin
+	}
+	return nil
+}
+
+// authenticated 仅要求已登录
+func (s *Server) authenticated(c *gin.Context, caller string) error {
+	return nil
+}
+
+func (s *Server) chatRule(c *gin.Context, caller string) error {
+	cR := &ChatRequest{}
+	if err := peekJSON(c, cR); err != nil {
+		return err
+	}
+	if err := requireSelf(caller, cR.From); err != nil {
+		return err
+	}
+	ok, err := s.isRoomMember(caller, cR.To)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotRoomMember
+	}
+	return nil
+}
+
+func (s *Server) loadRule(c *gin.Context, caller string) error {
+	lR := &api.LoadRequest{}
+	if err := peekJSON(c, lR); err != nil {
+		return err
+	}
+	return requireSelf(caller, lR.UID)
+}
+
+func (s *Server) friendRule(c *gin.Context, caller string) error {
+	fR := &api.FriendRequest{}
+	if err := peekJSON(c, fR); err != nil {
+		return err
+	}
+	return requireSelf(caller, fR.FriendA)
+}
+
+func (s *Server) groupSelfRule(c *gin.Context, caller string) error {
+	gR := &api.GroupRequest{}
+	if err := peekJSON(c, gR); err != nil {
+		return err
+	}
+	return requireSelf(caller, gR.UID)
+}
+
+// inviteRule 群内权限与好友关系由 InviteFriendsToGroup 校验
+func (s *Server) inviteRule(c *gin.Context, caller string) error {
+	iR := &InviteRequest{}
+	if err := peekJSON(c, iR); err != nil {
+		return err
+	}
+	return requireOperator(caller, iR.UID)
+}
+
+func (s *Server) pullRule(c *gin.Context, caller string) error {
+	pR := &api.PullRequest{}
+	if err := peekJSON(c, pR); err != nil {
+		return err
+	}
+	return s.checkPullAccess(caller, pR.UID, pR.FriendID, pR.GroupID)
+}
+
+func (s *Server) cursorPullRule(c *gin.Context, caller string) error {
+	pR := &CursorPullRequest{}
+	if err := peekJSON(c, pR); err != nil {
+		return err
+	}
+	return s.checkPullAccess(caller, pR.UID, pR.FriendID, pR.GroupID)
+}
+
+// checkPullAccess 拉取消息的公共校验
+func (s *Server) checkPullAccess(caller, uid, friendID, groupID string) error {
+	if err := requireSelf(caller, uid); err != nil {
+		return err
+	}
+	if len(friendID) == 0 && len(groupID) > 0 {
+		ok, err := s.isGroupMember(caller, groupID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrNotGroupMember
+		}
+	}
+	// 好友消息由 pullRoomID 通过 GetFriend(uid, friendID) 限定在双方房间内
+	return nil
+}
+
+func (s *Server) updateUserRule(c *gin.Context, caller string) error {
+	uR := &api.UpdateUserRequest{}
+	if err := peekJSON(c, uR); err != nil {
+		return err
+	}
+	return requireSelf(caller, uR.UID)
+}
+
+// updateGroupRule 群内权限由 UpdateGroupInfo 校验
+func (s *Server) updateGroupRule(c *gin.Context, caller string) error {
+	uR := &UpdateGroupRequest{}
+	if err := peekJSON(c, uR); err != nil {
+		return err
+	}
+	return requireOperator(caller, uR.UID)
+}
+
+func (s *Server) groupMemberRule(c *gin.Context, caller string) error {
+	gR := &GroupMemberRequest{}
+	if err := peekJSON(c, gR); err != nil {
+		return err
+	}
+	return requireSelf(caller, gR.UID)
+}
+
+func (s *Server) friendActionRule(c *gin.Context, caller string) error {
+	fR := &FriendRequestAction{}
+	if err := peekJSON(c, fR); err != nil {
+		return err
+	}
+	return requireSelf(caller, fR.UID)
+}
+
+func (s *Server) blockRule(c *gin.Context, caller string) error {
+	bR := &BlockRequest{}
+	if err := peekJSON(c, bR); err != nil {
+		return err
+	}
+	return requireSelf(caller, bR.UID)
+}
+
+func (s *Server) syncRule(c *gin.Context, caller string) error {
+	sR := &SyncRequest{}
+	if err := peekJSON(c, sR); err != nil {
+		return err
+	}
+	return requireSelf(caller, sR.UID)
+}
+
+func (s *Server) markReadRule(c *gin.Context, caller string) error {
+	mR := &MarkReadRequest{}
+	if err := peekJSON(c, mR); err != nil {
+		return err
+	}
+	if err := requireSelf(caller, mR.UID); err != nil {
+		return err
+	}
+	ok, err := s.isRoomMember(caller, mR.RoomID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotRoomMember
+	}
+	return nil
+}
+
+func (s *Server) ackRule(c *gin.Context, caller string) error {
+	aR := &AckRequest{}
+	if err := peekJSON(c, aR); err != nil {
+		return err
+	}
+	return requireSelf(caller, aR.UID)
+}
+
+func (s *Server) presenceRule(c *gin.Context, caller string) error {
+	pR := &PresenceRequest{}
+	if err := peekJSON(c, pR); err != nil {
+		return err
+	}
+	return requireSelf(caller, pR.UID)
+}
+
+// messageRule 房间成员与发送者的校验由 logic 在读取消息后完成
+func (s *Server) messageRule(c *gin.Context, caller string) error {
+	mR := &MessageRequest{}
+	if err := peekJSON(c, mR); err != nil {
+		return err
+	}
+	return requireSelf(caller, mR.UID)
+}
+
+// pullThreadRule 房间成员的校验由 PullThreadByPage 在读取首条消息后完成
+func (s *Server) pullThreadRule(c *gin.Context, caller string) error {
+	pR := &PullThreadRequest{}
+	if err := peekJSON(c, pR); err != nil {
+		return err
+	}
+	return requireSelf(caller, pR.UID)
+}
+
+// searchRule 房间范围由 SearchMessages 按好友与群组限定
+func (s *Server) searchRule(c *gin.Context, caller string) error {
+	sR := &SearchMessageRequest{}
+	if err := peekJSON(c, sR); err != nil {
+		return err
+	}
+	return requireSelf(caller, sR.UID)
+}
+
+func (s *Server) groupSettingRule(c *gin.Context, caller string) error {
+	gR := &GroupSettingRequest{}
+	if err := peekJSON(c, gR); err != nil {
+		return err
+	}
+	if err := requireSelf(caller, gR.UID); err != nil {
+		return err
+	}
+	_, err := s.checkGroupPermission(gR.GroupID, caller, permUpdateGroup)
+	return err
+}
+
+// joinRequestRule 审批权限由 logic 在读取申请后按所属群组校验
+func (s *Server) joinRequestRule(c *gin.Context, caller string) error {
+	jR := &JoinRequestAction{}
+	if err := peekJSON(c, jR); err != nil {
+		return err
+	}
+	return requireSelf(caller, jR.UID)
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"logic/conf"
+	"logic/dao"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// useMiniredis 在测试期间让 dao 使用内存中的 miniredis
+func useMiniredis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	restore := dao.UseRedisClient(client)
+	t.Cleanup(func() {
+		restore()
+		client.Close()
+	})
+	return mr
+}
+
+func newAuthServer(enabled bool) *Server {
+	s := NewServer()
+	s.logicCfg = conf.DefaultLogicConfig()
+	s.logicCfg.Auth.Enabled = enabled
+	return s
+}
+
+// serve 以 POST 请求调用 handler, 返回响应中的错误码, 成功时为 0
+func serve(t *testing.T, handler gin.HandlerFunc, token string, body interface{}) int {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	raw, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(raw))
+	if len(token) > 0 {
+		c.Request.Header.Set(TokenHeader, token)
+	}
+	handler(c)
+	resp := &ErrorResponse{}
+	if err = json.Unmarshal(recorder.Body.Bytes(), resp); err != nil {
+		t.Fatalf("decode response %q err: %v", recorder.Body.String(), err)
+	}
+	return resp.Code
+}
+
+// okHandler 记录调用者与请求体中的 uid, 校验 handler 仍能读取请求体
+func okHandler(caller, uid *string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sR := &SyncRequest{}
+		if err := c.BindJSON(sR); err != nil {
+			return
+		}
+		*caller, *uid = Caller(c), sR.UID
+		c.JSON(http.StatusOK, &ErrorResponse{})
+	}
+}
+
+func TestRequireOperator(t *testing.T) {
+	cases := []struct {
+		caller, uid string
+		want        error
+	}{
+		{"u1", "", nil},
+		{"u1", "u1", nil},
+		{"u1", "u2", ErrNotSelf},
+		{"", "u2", ErrNotSelf},
+	}
+	for _, c := range cases {
+		if err := requireOperator(c.caller, c.uid); err != c.want {
+			t.Errorf("requireOperator(%q, %q) = %v, want %v", c.caller, c.uid, err, c.want)
+		}
+	}
+}
+
+func TestAuthorizeSkippedWhenDisabled(t *testing.T) {
+	var caller, uid string
+	s := newAuthServer(false)
+	if code := serve(t, s.authorize(s.syncRule, okHandler(&caller, &uid)), "", &SyncRequest{UID: "u2"}); code != 0 {
+		t.Fatalf("disabled auth code = %v, want 0", code)
+	}
+	if len(caller) > 0 || uid != "u2" {
+		t.Fatalf("disabled auth caller = %q, uid = %q", caller, uid)
+	}
+
+	s = newAuthServer(true)
+	if code := serve(t, s.authorize(nil, okHandler(&caller, &uid)), "", &SyncRequest{UID: "u3"}); code != 0 || uid != "u3" {
+		t.Fatalf("route without rule code = %v, uid = %q", code, uid)
+	}
+}
+
+func TestAuthorizeRules(t *testing.T) {
+	useMiniredis(t)
+	if err := dao.SaveSession("t1", "u1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	s := newAuthServer(true)
+	cases := []struct {
+		name   string
+		token  string
+		body   interface{}
+		code   int
+		caller string
+	}{
+		{"header token", "t1", &SyncRequest{UID: "u1"}, 0, "u1"},
+		{"body token", "", map[string]string{"uid": "u1", "token": "t1"}, 0, "u1"},
+		{"other user", "t1", &SyncRequest{UID: "u2"}, ErrorCodeForbidden, ""},
+		{"no token", "", &SyncRequest{UID: "u1"}, ErrorCodeUnauthenticated, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var caller, uid string
+			code := serve(t, s.authorize(s.syncRule, okHandler(&caller, &uid)), c.token, c.body)
+			if code != c.code || caller != c.caller {
+				t.Fatalf("code = %v, caller = %q, want %v, %q", code, caller, c.code, c.caller)
+			}
+			if code == 0 && uid != "u1" {
+				t.Fatalf("handler read uid %q from the request body, want u1", uid)
+			}
+		})
+	}
+}
+
+func TestAdminRouteIgnoresAuthEnabled(t *testing.T) {
+	useMiniredis(t)
+	for token, uid := range map[string]string{"t1": "u1", "t2": "u2"} {
+		if err := dao.SaveSession(token, uid, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := newAuthServer(false)
+	s.logicCfg.Auth.Admins = []string{"u1"}
+	var caller, uid string
+	handler := s.enforce(s.adminRule, okHandler(&caller, &uid))
+	if code := serve(t, handler, "t1", &SyncRequest{}); code != 0 || caller != "u1" {
+		t.Fatalf("admin code = %v, caller = %q", code, caller)
+	}
+	if code := serve(t, handler, "t2", &SyncRequest{}); code != ErrorCodeForbidden {
+		t.Fatalf("non-admin code = %v, want %v", code, ErrorCodeForbidden)
+	}
+	if code := serve(t, handler, "", &SyncRequest{}); code != ErrorCodeUnauthenticated {
+		t.Fatalf("anonymous code = %v, want %v", code, ErrorCodeUnauthenticated)
+	}
+}
+
+func TestSaveSessionTTL(t *testing.T) {
+	mr := useMiniredis(t)
+	s := newAuthServer(true)
+	s.logicCfg.Auth.SessionTTL = 0
+	s.saveSession("t1", "u1")
+	if uid, _ := dao.GetSessionUID("t1"); len(uid) > 0 {
+		t.Fatalf("session cached with sessionTTL 0")
+	}
+	s.logicCfg.Auth.SessionTTL = 300
+	s.saveSession("t1", "u1")
+	if uid, _ := dao.GetSessionUID("t1"); uid != "u1" {
+		t.Fatalf("cached session = %q, want u1", uid)
+	}
+	if ttl := mr.TTL("logic:session:t1"); ttl != 300*time.Second {
+		t.Fatalf("session ttl = %v, want 5m", ttl)
+	}
+}
+
+func TestLogoutDropsSession(t *testing.T) {
+	useMiniredis(t)
+	if err := dao.SaveSession("t1", "u1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	s := newAuthServer(true)
+	// 成功响应的错误码由 framework 决定, 只校验会话已移除
+	serve(t, s.Logout, "t1", struct{}{})
+	if uid, _ := dao.GetSessionUID("t1"); len(uid) > 0 {
+		t.Fatalf("session still cached after logout")
+	}
+	if code := serve(t, s.Logout, "", struct{}{}); code != ErrorCodeUnauthenticated {
+		t.Fatalf("logout without token code = %v, want %v", code, ErrorCodeUnauthenticated)
+	}
+}
+
+func TestAuthorizeGate(t *testing.T) {
+	useMiniredis(t)
+	if err := dao.SaveSession("t1", "u1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	cases := []struct {
+		name   string
+		secret string
+		header string
+		token  string
+		code   int
+	}{
+		{"matching secret", "s1", "s1", "", 0},
+		{"wrong secret", "s1", "s2", "", ErrorCodeUnauthenticated},
+		{"user token only", "s1", "", "t1", ErrorCodeUnauthenticated},
+		{"no secret configured", "", "", "t1", ErrorCodeUnauthenticated},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := newAuthServer(true)
+			s.gates = newGateClient(map[string]string{"g1": "http://g1"}, c.secret, time.Second)
+			called := false
+			handler := s.authorizeGate(func(c *gin.Context) {
+				called = true
+				c.JSON(http.StatusOK, &ErrorResponse{})
+			})
+			recorder := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(recorder)
+			ctx.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(`{"uid":"u1"}`)))
+			ctx.Request.Header.Set(GateHeader, "g1")
+			if len(c.header) > 0 {
+				ctx.Request.Header.Set(GateSecretHeader, c.header)
+			}
+			if len(c.token) > 0 {
+				ctx.Request.Header.Set(TokenHeader, c.token)
+			}
+			handler(ctx)
+			resp := &ErrorResponse{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != c.code || called != (c.code == 0) {
+				t.Fatalf("code = %v, handler called = %v, want %v", resp.Code, called, c.code)
+			}
+		})
+	}
+
+	// 未开启鉴权时不校验密钥
+	s := newAuthServer(false)
+	s.gates = newGateClient(nil, "s1", time.Second)
+	var caller, uid string
+	if code := serve(t, s.authorizeGate(okHandler(&caller, &uid)), "", &SyncRequest{UID: "u1"}); code != 0 || uid != "u1" {
+		t.Fatalf("disabled auth code = %v, uid = %q", code, uid)
+	}
+}
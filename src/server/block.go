@@ -0,0 +1,143 @@
+package server
+
+import (
+	"framework/api"
+	"framework/api/model"
+	"framework/logger"
+	"logic/dao"
+)
+
+var (
+	ErrBlockSelf = NewCodeError(ErrorCodeForbidden, "can not block yourself")
+	ErrBlocked   = NewCodeError(ErrorCodeForbidden, "one of the users has blocked the other")
+)
+
+// BlockUser 屏蔽用户, 屏蔽后对方的单聊消息、好友申请不再送达, 搜索中互相不可见
+func (s *Server) BlockUser(uid, target string) error {
+	if uid == target {
+		return ErrBlockSelf
+	}
+	err := dao.AddUserBlock(uid, target)
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+	}
+	return err
+}
+
+func (s *Server) UnblockUser(uid, target string) error {
+	err := dao.DeleteUserBlock(uid, target)
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+	}
+	return err
+}
+
+// GetBlockList 返回用户屏蔽的人
+func (s *Server) GetBlockList(uid string) ([]string, error) {
+	return dao.GetBlockedUIDs(uid)
+}
+
+// hiddenUIDs 返回 uid 屏蔽的与屏蔽了 uid 的用户
+func (s *Server) hiddenUIDs(uid string) (map[string]bool, error) {
+	hidden := make(map[string]bool)
+	blocked, err := dao.GetBlockedUIDs(uid)
+	if err != nil {
+		return nil, err
+	}
+	blockers, err := dao.GetBlockerUIDs(uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range append(blocked, blockers...) {
+		hidden[id] = true
+	}
+	return hidden, nil
+}
+
+// checkChatAllowed 单聊的接收方屏蔽了发送者时拒绝发送, 消息不会写入对方的历史与未读数
+func (s *Server) checkChatAllowed(from, roomID string) error {
+	room, err := model.GetRoomByID(roomID)
+	if err != nil {
+		return err
+	}
+	if !room.OneToOne {
+		return nil
+	}
+	uids, err := model.GetFriendsByRoomID(room.RoomID)
+	if err != nil {
+		return err
+	}
+	for _, uid := range uids {
+		if uid == from {
+			continue
+		}
+		blocked, err := dao.IsBlocking(uid, from)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			return ErrBlocked
+		}
+	}
+	return nil
+}
+
+// filterChatTargets 单聊时去掉屏蔽了发送者的接收方, 兜底发送校验之后才生效的屏蔽
+func (s *Server) filterChatTargets(from string, targets []string) []string {
+	blockers, err := dao.GetBlockerUIDs(from)
+	if err != nil {
+		logger.Error("Logic.filterChatTargets get blockers err: %v", err)
+		return targets
+	}
+	if len(blockers) == 0 {
+		return targets
+	}
+	filtered := make([]string, 0, len(targets))
+	for _, target := range targets {
+		if !containsString(blockers, target) {
+			filtered = append(filtered, target)
+		}
+	}
+	return filtered
+}
+
+// filterBlockedFriends 去掉用户屏蔽的好友
+func (s *Server) filterBlockedFriends(uid string, friends []*model.FriendData) ([]*model.FriendData, error) {
+	blocked, err := dao.GetBlockedUIDs(uid)
+	if err != nil {
+		return nil, err
+	}
+	if len(blocked) == 0 {
+		return friends, nil
+	}
+	filtered := make([]*model.FriendData, 0, len(friends))
+	for _, friend := range friends {
+		if friend.User != nil && containsString(blocked, friend.User.UID) {
+			continue
+		}
+		filtered = append(filtered, friend)
+	}
+	return filtered, nil
+}
+
+// FindVisibleUsers 模糊搜索用户, 去掉与搜索者存在屏蔽关系的用户
+func (s *Server) FindVisibleUsers(uid, account string) ([]*model.User, error) {
+	users, err := dao.FindUserCandidates(account, maxFindCandidates)
+	if err != nil {
+		return nil, err
+	}
+	if len(uid) == 0 {
+		return users, nil
+	}
+	hidden, err := s.hiddenUIDs(uid)
+	if err != nil {
+		return nil, err
+	}
+	visible := make([]*model.User, 0, len(users))
+	for _, user := range users {
+		if !hidden[user.UID] {
+			visible = append(visible, user)
+		}
+	}
+	return visible, nil
+}
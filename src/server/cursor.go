@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"logic/dao"
+	"time"
+)
+
+const (
+	PullDirectionBefore = "before"
+	PullDirectionAfter  = "after"
+
+	defaultPullLimit = 20
+	maxPullLimit     = 100
+)
+
+var ErrInvalidCursor = NewCodeError(ErrorCodeInvalidParam, "invalid message cursor")
+
+// messageCursor 编码在 nextCursor 中, 客户端只需原样传回
+type messageCursor struct {
+	RoomID    string `json:"r"`
+	MessageID string `json:"m"`
+	Direction string `json:"d"`
+}
+
+func encodeCursor(c *messageCursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string) (*messageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := &messageCursor{}
+	if err = json.Unmarshal(raw, c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// CursorPage 一页消息, 按时间正序
+type CursorPage struct {
+	Messages   []*dao.ChatMessage `json:"messages"`
+	NextCursor string             `json:"nextCursor"`
+	HasMore    bool               `json:"hasMore"`
+}
+
+// PullMessageByCursor 游标优先, 其次是消息 ID, 再次是毫秒时间戳, 都为空时从最新 (before) 或最早 (after) 开始
+// 游标中记录了方向, 传入游标时忽略 direction
+func (s *Server) PullMessageByCursor(pR *CursorPullRequest) (*CursorPage, error) {
+	roomID, err := s.pullRoomID(pR.UID, pR.FriendID, pR.GroupID)
+	if err != nil {
+		return nil, err
+	}
+	direction, anchor := pR.Direction, pR.MessageID
+	if len(pR.Cursor) > 0 {
+		c, err := decodeCursor(pR.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if c.RoomID != roomID {
+			return nil, ErrInvalidCursor
+		}
+		direction, anchor = c.Direction, c.MessageID
+	} else if len(anchor) == 0 && pR.Timestamp > 0 {
+		anchor = dao.MessageIDAt(time.Unix(0, pR.Timestamp*int64(time.Millisecond)))
+	}
+	if direction != PullDirectionAfter {
+		direction = PullDirectionBefore
+	}
+	limit := pR.Limit
+	if limit <= 0 {
+		limit = defaultPullLimit
+	}
+	if limit > maxPullLimit {
+		limit = maxPullLimit
+	}
+	before := direction == PullDirectionBefore
+	messages, hasMore, err := dao.GetRoomMessagesAround(roomID, pR.UID, anchor, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	page := &CursorPage{Messages: messages, HasMore: hasMore}
+	next := anchor
+	if len(messages) > 0 {
+		next = messages[len(messages)-1].MessageID
+		if before {
+			next = messages[0].MessageID
+		}
+	}
+	page.NextCursor = encodeCursor(&messageCursor{RoomID: roomID, MessageID: next, Direction: direction})
+	return page, nil
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	cases := []*messageCursor{
+		{RoomID: "room", MessageID: "m1", Direction: PullDirectionBefore},
+		{RoomID: "room", MessageID: "m2", Direction: PullDirectionAfter},
+		{},
+	}
+	for _, c := range cases {
+		got, err := decodeCursor(encodeCursor(c))
+		if err != nil {
+			t.Fatalf("decodeCursor(encodeCursor(%+v)) err: %v", c, err)
+		}
+		if !reflect.DeepEqual(got, c) {
+			t.Errorf("decodeCursor(encodeCursor(%+v)) = %+v", c, got)
+		}
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	cases := map[string]string{
+		"not base64":  "!!!",
+		"not json":    base64.RawURLEncoding.EncodeToString([]byte("room")),
+		"padded":      base64.URLEncoding.EncodeToString([]byte(`{"r":"ro"}`)),
+		"wrong types": base64.RawURLEncoding.EncodeToString([]byte(`{"r":1}`)),
+	}
+	for name, cursor := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := decodeCursor(cursor); err != ErrInvalidCursor {
+				t.Errorf("decodeCursor(%q) err = %v, want ErrInvalidCursor", cursor, err)
+			}
+		})
+	}
+}
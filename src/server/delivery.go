@@ -0,0 +1,92 @@
+package server
+
+import (
+	"framework/api"
+	"framework/logger"
+	"logic/dao"
+	"time"
+)
+
+// AckResult 客户端确认结果
+type AckResult struct {
+	Acked int64 `json:"acked"`
+}
+
+// OfflineMessages 重新连接后补发的未确认消息
+type OfflineMessages struct {
+	Messages []*dao.ChatMessage `json:"messages"`
+	More     bool               `json:"more"`
+}
+
+// deliverChatMessage 先写入接收者收件箱再推送, 推送成功后标记为已送达, 客户端确认后移除
+func (s *Server) deliverChatMessage(message *dao.ChatMessage, targets []string) {
+	cfg := s.logicCfg.Inbox
+	if !cfg.Enabled {
+		s.InvokeTarget(api.EventChat, message, targets...)
+		return
+	}
+	recipients := make([]string, 0, len(targets))
+	for _, target := range targets {
+		if target != message.From {
+			recipients = append(recipients, target)
+		}
+	}
+	if err := dao.AddToInbox(recipients, message, cfg.MaxSize, time.Duration(cfg.TTL)*time.Second); err != nil {
+		logger.Error("Logic.deliverChatMessage add to inbox err: %v", err)
+	}
+	s.InvokeTargetWithCallback(api.EventChat, message, func(err error) {
+		if err != nil {
+			// 留在收件箱, 等待重连时补发
+			return
+		}
+		if err := dao.MarkInboxDeliveredFor(recipients, message.MessageID); err != nil {
+			logger.Error("Logic.deliverChatMessage mark delivered err: %v", err)
+		}
+	}, targets...)
+}
+
+// FlushInbox 补发用户收件箱中尚未确认的消息
+func (s *Server) FlushInbox(uid string) {
+	cfg := s.logicCfg.Inbox
+	if !cfg.Enabled {
+		return
+	}
+	limit := int64(cfg.FlushLimit)
+	inbox, err := dao.GetInbox(uid, limit+1)
+	if err != nil {
+		logger.Error("Logic.FlushInbox get inbox err: %v", err)
+		return
+	}
+	if len(inbox) == 0 {
+		return
+	}
+	more := int64(len(inbox)) > limit
+	if more {
+		inbox = inbox[:limit]
+	}
+	offline := &OfflineMessages{Messages: make([]*dao.ChatMessage, 0, len(inbox)), More: more}
+	ids := make([]string, 0, len(inbox))
+	for _, item := range inbox {
+		offline.Messages = append(offline.Messages, item.Message)
+		ids = append(ids, item.Message.MessageID)
+	}
+	logger.Info("Logic.FlushInbox uid: %v, messages: %v, more: %v", uid, len(ids), more)
+	s.InvokeTargetWithCallback(EventOfflineMessages, offline, func(err error) {
+		if err != nil {
+			return
+		}
+		if err := dao.MarkInboxDelivered(uid, ids...); err != nil {
+			logger.Error("Logic.FlushInbox mark delivered err: %v", err)
+		}
+	}, uid)
+}
+
+// AckMessages 客户端确认收到消息, 从收件箱移除
+func (s *Server) AckMessages(uid string, messageIDs []string) (*AckResult, error) {
+	acked, err := dao.AckInbox(uid, messageIDs...)
+	if err != nil {
+		logger.Error("Logic.AckMessages err: %v", err)
+		return nil, err
+	}
+	return &AckResult{Acked: acked}, nil
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"framework/api/model"
+	"framework/logger"
+	"logic/dao"
+	"sort"
+	"strings"
+)
+
+const maxFindLimit = 50
+
+// maxFindCandidates 每种匹配程度最多从库中取出的候选数, 排序与翻页只在这些候选中进行
+const maxFindCandidates = 200
+
+// 匹配程度, 越小越靠前
+const (
+	matchExact = iota
+	matchPrefix
+	matchFuzzy
+)
+
+// FindUsersResponse 传入 limit 或 cursor 时返回的分页结果
+type FindUsersResponse struct {
+	Users      []*model.User `json:"users"`
+	NextCursor string        `json:"nextCursor"`
+	HasMore    bool          `json:"hasMore"`
+}
+
+// FindGroupsResponse 传入 limit 或 cursor 时返回的分页结果
+type FindGroupsResponse struct {
+	Groups     []*model.Group `json:"groups"`
+	NextCursor string         `json:"nextCursor"`
+	HasMore    bool           `json:"hasMore"`
+}
+
+// rankKey 排序键, 同时编码在 nextCursor 中
+type rankKey struct {
+	Rank int    `json:"r"`
+	Name string `json:"n"`
+	ID   string `json:"i"`
+}
+
+func (k *rankKey) less(o *rankKey) bool {
+	if k.Rank != o.Rank {
+		return k.Rank < o.Rank
+	}
+	if k.Name != o.Name {
+		return k.Name < o.Name
+	}
+	return k.ID < o.ID
+}
+
+func newRankKey(query, name, id string) *rankKey {
+	q, n := strings.ToLower(query), strings.ToLower(name)
+	rank := matchFuzzy
+	if n == q {
+		rank = matchExact
+	} else if strings.HasPrefix(n, q) {
+		rank = matchPrefix
+	}
+	return &rankKey{Rank: rank, Name: n, ID: id}
+}
+
+func encodeRankCursor(k *rankKey) string {
+	raw, _ := json.Marshal(k)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeRankCursor(s string) (*rankKey, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	k := &rankKey{}
+	if err = json.Unmarshal(raw, k); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return k, nil
+}
+
+// rankPage 按完全匹配, 前缀匹配, 模糊匹配排序后取 cursor 之后的 limit 条, 返回选中的下标
+func rankPage(keys []*rankKey, cursor string, limit int) (indexes []int, next string, hasMore bool, err error) {
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return keys[order[a]].less(keys[order[b]])
+	})
+	var after *rankKey
+	if len(cursor) > 0 {
+		if after, err = decodeRankCursor(cursor); err != nil {
+			return nil, "", false, err
+		}
+	}
+	for _, i := range order {
+		if after != nil && !after.less(keys[i]) {
+			continue
+		}
+		if limit > 0 && len(indexes) == limit {
+			hasMore = true
+			break
+		}
+		indexes = append(indexes, i)
+	}
+	if hasMore {
+		next = encodeRankCursor(keys[indexes[len(indexes)-1]])
+	}
+	return indexes, next, hasMore, nil
+}
+
+func findLimit(limit int) int {
+	if limit > maxFindLimit {
+		return maxFindLimit
+	}
+	return limit
+}
+
+// FindUsersRanked 去掉搜索者自己, 与搜索者有屏蔽关系的用户, excludeFriends 时去掉已有好友
+func (s *Server) FindUsersRanked(fR *FindRequest) (*FindUsersResponse, error) {
+	users, err := s.FindVisibleUsers(fR.UID, fR.Account)
+	if err != nil {
+		return nil, err
+	}
+	friends := make(map[string]bool)
+	if fR.ExcludeFriends && len(fR.UID) > 0 {
+		fds, err := model.GetFriendDatasByUID(fR.UID)
+		if err != nil {
+			return nil, err
+		}
+		for _, uid := range friendUIDs(fds) {
+			friends[uid] = true
+		}
+	}
+	candidates := make([]*model.User, 0, len(users))
+	keys := make([]*rankKey, 0, len(users))
+	for _, user := range users {
+		if user.UID == fR.UID || friends[user.UID] {
+			continue
+		}
+		candidates = append(candidates, user)
+		keys = append(keys, newRankKey(fR.Account, user.Account, user.UID))
+	}
+	indexes, next, hasMore, err := rankPage(keys, fR.Cursor, findLimit(fR.Limit))
+	if err != nil {
+		return nil, err
+	}
+	resp := &FindUsersResponse{Users: make([]*model.User, 0, len(indexes)), NextCursor: next, HasMore: hasMore}
+	for _, i := range indexes {
+		resp.Users = append(resp.Users, candidates[i])
+	}
+	return resp, nil
+}
+
+// FindGroupsRanked 去掉私有群组与只能邀请加入的群组
+func (s *Server) FindGroupsRanked(fR *FindRequest) (*FindGroupsResponse, error) {
+	groups, err := dao.FindGroupCandidates(fR.GroupName, maxFindCandidates)
+	if err != nil {
+		return nil, err
+	}
+	groupIDs := make([]string, 0, len(groups))
+	for _, group := range groups {
+		groupIDs = append(groupIDs, group.GroupID)
+	}
+	settings, err := dao.GetGroupSettings(groupIDs)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]*model.Group, 0, len(groups))
+	keys := make([]*rankKey, 0, len(groups))
+	for _, group := range groups {
+		setting := settings[group.GroupID]
+		if setting.Private || setting.JoinPolicy == dao.GroupJoinInviteOnly {
+			continue
+		}
+		candidates = append(candidates, group)
+		keys = append(keys, newRankKey(fR.GroupName, group.GroupName, group.GroupID))
+	}
+	indexes, next, hasMore, err := rankPage(keys, fR.Cursor, findLimit(fR.Limit))
+	if err != nil {
+		return nil, err
+	}
+	resp := &FindGroupsResponse{Groups: make([]*model.Group, 0, len(indexes)), NextCursor: next, HasMore: hasMore}
+	for _, i := range indexes {
+		resp.Groups = append(resp.Groups, candidates[i])
+	}
+	return resp, nil
+}
+
+// SaveGroupSetting 只更新请求中非空的字段, 修改加入方式不影响已提交的申请
+func (s *Server) SaveGroupSetting(gR *GroupSettingRequest) (*dao.GroupSetting, error) {
+	if gR.JoinPolicy != nil && !joinPolicies[*gR.JoinPolicy] {
+		return nil, ErrInvalidJoinPolicy
+	}
+	setting, err := dao.UpdateGroupSetting(gR.GroupID, gR.Private, gR.JoinPolicy)
+	if err != nil {
+		logger.Error("Logic.SaveGroupSetting err: %v", err)
+		return nil, err
+	}
+	return setting, nil
+}
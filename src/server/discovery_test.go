@@ -0,0 +1,82 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewRankKey(t *testing.T) {
+	cases := []struct {
+		query, name string
+		want        int
+	}{
+		{"ab", "ab", matchExact},
+		{"ab", "AB", matchExact},
+		{"ab", "abc", matchPrefix},
+		{"AB", "abc", matchPrefix},
+		{"ab", "xab", matchFuzzy},
+	}
+	for _, c := range cases {
+		if got := newRankKey(c.query, c.name, "id").Rank; got != c.want {
+			t.Errorf("newRankKey(%q, %q).Rank = %v, want %v", c.query, c.name, got, c.want)
+		}
+	}
+}
+
+func TestRankPage(t *testing.T) {
+	names := []string{"xab", "ab", "Abd", "abc", "abc"}
+	ids := []string{"u0", "u1", "u2", "u4", "u3"}
+	keys := make([]*rankKey, 0, len(names))
+	for i, name := range names {
+		keys = append(keys, newRankKey("ab", name, ids[i]))
+	}
+
+	// 完全匹配, 前缀匹配按名称再按 ID, 最后是模糊匹配
+	indexes, next, hasMore, err := rankPage(keys, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 4, 3, 2, 0}; !reflect.DeepEqual(indexes, want) || hasMore || len(next) > 0 {
+		t.Fatalf("rankPage without limit = %v, %q, %v, want %v", indexes, next, hasMore, want)
+	}
+
+	pages := [][]int{{1, 4}, {3, 2}, {0}}
+	cursor := ""
+	for i, want := range pages {
+		indexes, next, hasMore, err = rankPage(keys, cursor, 2)
+		if err != nil {
+			t.Fatalf("page %v err: %v", i, err)
+		}
+		if !reflect.DeepEqual(indexes, want) {
+			t.Fatalf("page %v = %v, want %v", i, indexes, want)
+		}
+		if last := i == len(pages)-1; hasMore == last || (len(next) == 0) != last {
+			t.Fatalf("page %v hasMore = %v, next = %q", i, hasMore, next)
+		}
+		cursor = next
+	}
+
+	// 上一页最后一条被删除后, 游标仍能定位到其后的结果
+	indexes, _, _, err = rankPage(keys[:4], encodeRankCursor(newRankKey("ab", "abc", "u3")), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{3, 2}; !reflect.DeepEqual(indexes, want) {
+		t.Fatalf("page after removed key = %v, want %v", indexes, want)
+	}
+
+	if _, _, _, err = rankPage(keys, "!!!", 2); err != ErrInvalidCursor {
+		t.Fatalf("rankPage with bad cursor err = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestRankCursorRoundTrip(t *testing.T) {
+	k := &rankKey{Rank: matchPrefix, Name: "abc", ID: "u1"}
+	got, err := decodeRankCursor(encodeRankCursor(k))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, k) {
+		t.Fatalf("decodeRankCursor(encodeRankCursor(%+v)) = %+v", k, got)
+	}
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"errors"
+	"framework/api"
+	"logic/dao"
+)
+
+// logic 自定义错误码, 与 api 内置错误码区分
+const (
+	ErrorCodeInvalidParam    = 4000
+	ErrorCodeUnauthenticated = 4001
+	ErrorCodeForbidden       = 4003
+	ErrorCodeNotFound        = 4004
+	ErrorCodeConflict        = 4009
+)
+
+// CodeError 携带错误码的业务错误
+type CodeError struct {
+	Code    int
+	Message string
+}
+
+func (e *CodeError) Error() string {
+	return e.Message
+}
+
+func NewCodeError(code int, message string) *CodeError {
+	return &CodeError{Code: code, Message: message}
+}
+
+var (
+	ErrUnauthenticated = NewCodeError(ErrorCodeUnauthenticated, "caller is not authenticated")
+	ErrNotSelf         = NewCodeError(ErrorCodeForbidden, "caller does not match the request user")
+	ErrNotRoomMember   = NewCodeError(ErrorCodeForbidden, "caller is not a member of the room")
+	ErrNotGroupMember  = NewCodeError(ErrorCodeForbidden, "caller is not a member of the group")
+	ErrNotFriend       = NewCodeError(ErrorCodeForbidden, "target is not a friend of the caller")
+	ErrNotAdmin        = NewCodeError(ErrorCodeForbidden, "caller is not an administrator")
+	ErrChatInProgress  = NewCodeError(ErrorCodeConflict, dao.ErrChatInProgress.Error())
+)
+
+// ErrorResponse 与 api 响应结构一致, 用于返回 CodeError 的错误码
+type ErrorResponse struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data"`
+}
+
+// NewErrorResponse CodeError 返回对应错误码, 其余错误按内部错误处理
+func NewErrorResponse(err error) interface{} {
+	var codeErr *CodeError
+	if errors.As(err, &codeErr) {
+		return &ErrorResponse{Code: codeErr.Code, Message: codeErr.Message}
+	}
+	return api.NewHttpInnerErrorResponse(err)
+}
@@ -0,0 +1,84 @@
+package server
+
+// logic 新增的事件, framework/api 中尚未定义
+const (
+	// EventLogout 用户登出或 token 被吊销, 移除会话缓存
+	EventLogout = "logout"
+
+	EventPromoteAdmin  = "promoteAdmin"
+	EventDemoteAdmin   = "demoteAdmin"
+	EventTransferOwner = "transferOwner"
+	EventKickMember    = "kickMember"
+	EventBanMember     = "banMember"
+	EventUnbanMember   = "unbanMember"
+
+	EventAcceptFriend = "acceptFriend"
+	EventRejectFriend = "rejectFriend"
+	EventCancelFriend = "cancelFriend"
+
+	EventBlockUser    = "blockUser"
+	EventUnblockUser  = "unblockUser"
+	EventGetBlockList = "getBlockList"
+
+	EventSync     = "sync"
+	EventMarkRead = "markRead"
+	EventAck      = "ack"
+	// EventConnect gate 通知已鉴权用户的一个连接已建立
+	EventConnect = "connect"
+	// EventDisconnect gate 通知用户的一个连接已断开
+	EventDisconnect  = "disconnect"
+	EventSetPresence = "setPresence"
+	// EventTyping 客户端上报输入状态, 服务端以同名事件推送给房间其他成员
+	EventTyping = "typing"
+
+	EventRecallMessage   = "recallMessage"
+	EventEditMessage     = "editMessage"
+	EventDeleteMessage   = "deleteMessage"
+	EventGetMessageEdits = "getMessageEdits"
+	EventPullThread      = "pullThread"
+	// EventPullMessageCursor 游标分页拉取消息, 旧客户端继续使用 api.EventPullMessage
+	EventPullMessageCursor  = "pullMessageCursor"
+	EventSearchMessage      = "searchMessage"
+	EventUpdateGroupSetting = "updateGroupSetting"
+	EventApproveJoin        = "approveJoin"
+	EventRejectJoin         = "rejectJoin"
+	EventGetJoinRequests    = "getJoinRequests"
+
+	// 管理接口
+	EventGetDeadLetters    = "getDeadLetters"
+	EventReplayDeadLetters = "replayDeadLetters"
+	EventGetMetrics        = "getMetrics"
+
+	// EventGroupRoleChanged 服务端推送: 群成员角色变化
+	EventGroupRoleChanged = "groupRoleChanged"
+	// EventGroupMemberRemoved 服务端推送: 有成员被移出群组, 发给剩余成员
+	EventGroupMemberRemoved = "groupMemberRemoved"
+	// EventRemovedFromGroup 服务端推送: 被移出群组, 只发给被移出的用户
+	EventRemovedFromGroup = "removedFromGroup"
+	// EventFriendRequestReceived 服务端推送: 收到好友申请
+	EventFriendRequestReceived = "friendRequestReceived"
+	// EventFriendRequestAccepted 服务端推送: 好友申请被接受, 发给申请方
+	EventFriendRequestAccepted = "friendRequestAccepted"
+	// EventFriendRequestRejected 服务端推送: 好友申请被拒绝, 发给申请方
+	EventFriendRequestRejected = "friendRequestRejected"
+	// EventFriendRequestCanceled 服务端推送: 好友申请被撤回, 发给接收方
+	EventFriendRequestCanceled = "friendRequestCanceled"
+	// EventReadReceipt 服务端推送: 单聊对方的已读位置
+	EventReadReceipt = "readReceipt"
+	// EventOfflineMessages 服务端推送: 重连后补发未确认的消息
+	EventOfflineMessages = "offlineMessages"
+	// EventPresenceChanged 服务端推送: 好友上线或下线
+	EventPresenceChanged = "presenceChanged"
+	// EventMessageRecalled 服务端推送: 房间内的消息被撤回
+	EventMessageRecalled = "messageRecalled"
+	// EventMessageEdited 服务端推送: 房间内的消息被编辑
+	EventMessageEdited = "messageEdited"
+	// EventMention 服务端推送: 在群聊中被提及
+	EventMention = "mention"
+	// EventGroupJoinRequested 服务端推送: 群主与管理员收到新的入群申请
+	EventGroupJoinRequested = "groupJoinRequested"
+	// EventGroupJoinApproved 服务端推送: 申请人的入群申请已通过
+	EventGroupJoinApproved = "groupJoinApproved"
+	// EventGroupJoinRejected 服务端推送: 申请人的入群申请被拒绝
+	EventGroupJoinRejected = "groupJoinRejected"
+)
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"framework/api"
+	"framework/api/model"
+	"framework/db"
+	"framework/logger"
+	"go.mongodb.org/mongo-driver/mongo"
+	"logic/dao"
+)
+
+var (
+	ErrAlreadyFriends         = NewCodeError(ErrorCodeForbidden, "users are already friends")
+	ErrAddSelf                = NewCodeError(ErrorCodeForbidden, "can not add yourself as a friend")
+	ErrFriendRequestNotFound  = NewCodeError(ErrorCodeForbidden, "friend request not found")
+	ErrFriendRequestHandled   = NewCodeError(ErrorCodeForbidden, "friend request has been handled")
+	ErrNotFriendRequestTarget = NewCodeError(ErrorCodeForbidden, "caller can not handle this friend request")
+)
+
+func (s *Server) isFriend(uid, friendID string) (bool, error) {
+	_, err := model.GetFriend(uid, friendID)
+	if err == nil {
+		return true, nil
+	}
+	if db.IsNotExistError(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// SendFriendRequest 发送好友申请; 对方已向自己发出待处理申请时直接互加好友
+// 返回申请, 直接成为好友时 friendData 非空
+func (s *Server) SendFriendRequest(from, to, greeting string) (*dao.FriendRequest, *model.FriendData, error) {
+	if from == to {
+		return nil, nil, ErrAddSelf
+	}
+	friend, err := s.isFriend(from, to)
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+		return nil, nil, err
+	}
+	if friend {
+		return nil, nil, ErrAlreadyFriends
+	}
+	blocked, err := dao.IsBlockedBetween(from, to)
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+		return nil, nil, err
+	}
+	if blocked {
+		return nil, nil, ErrBlocked
+	}
+
+	reverse, err := dao.GetPendingFriendRequest(to, from)
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+		return nil, nil, err
+	}
+	if reverse != nil {
+		friendData, err := s.AcceptFriendRequest(from, reverse.RequestID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return reverse, friendData, nil
+	}
+
+	request, err := dao.GetPendingFriendRequest(from, to)
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+		return nil, nil, err
+	}
+	if request != nil {
+		// 重复申请只更新招呼语并再次提醒
+		request.Greeting = greeting
+		if _, err = dao.UpdateFriendRequest(request); err != nil {
+			logger.Error(api.MongoDBError, err)
+			return nil, nil, err
+		}
+	} else {
+		request = dao.NewFriendRequest(from, to, greeting)
+		err = dao.InsertFriendRequest(request)
+		if mongo.IsDuplicateKeyError(err) {
+			// 并发发送的另一条申请已经写入, 返回那一条
+			request, err = dao.GetPendingFriendRequest(from, to)
+			if err == nil && request == nil {
+				err = ErrFriendRequestHandled
+			}
+		}
+		if err != nil {
+			logger.Error(api.MongoDBError, err)
+			return nil, nil, err
+		}
+	}
+	s.InvokeTarget(EventFriendRequestReceived, request, to)
+	return request, nil, nil
+}
+
+// handleFriendRequest 校验申请存在且待处理, operator 需为 uid 指定的一方
+func (s *Server) handleFriendRequest(operator, requestID, status string) (*dao.FriendRequest, error) {
+	request, err := dao.GetFriendRequest(requestID)
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+		return nil, err
+	}
+	if request == nil {
+		return nil, ErrFriendRequestNotFound
+	}
+	allowed := request.To
+	if status == dao.FriendRequestCanceled {
+		allowed = request.From
+	}
+	if operator != allowed {
+		return nil, ErrNotFriendRequestTarget
+	}
+	request.Status = status
+	updated, err := dao.UpdateFriendRequest(request)
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+		return nil, err
+	}
+	if !updated {
+		return nil, ErrFriendRequestHandled
+	}
+	return request, nil
+}
+
+// AcceptFriendRequest 接受申请并互加好友, 加好友失败时申请恢复为待处理
+// 申请发出后任意一方屏蔽了对方时不能接受
+func (s *Server) AcceptFriendRequest(uid, requestID string) (*model.FriendData, error) {
+	pending, err := dao.GetFriendRequest(requestID)
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+		return nil, err
+	}
+	if pending != nil {
+		blocked, err := dao.IsBlockedBetween(pending.From, pending.To)
+		if err != nil {
+			logger.Error(api.MongoDBError, err)
+			return nil, err
+		}
+		if blocked {
+			return nil, ErrBlocked
+		}
+	}
+	request, err := s.handleFriendRequest(uid, requestID, dao.FriendRequestAccepted)
+	if err != nil {
+		return nil, err
+	}
+	if err = model.AddNewFriend(request.To, request.From); err != nil {
+		logger.Error(api.MongoDBError, err)
+		if rErr := dao.RevertFriendRequest(request.RequestID, dao.FriendRequestAccepted); rErr != nil {
+			logger.Error("Logic.AcceptFriendRequest revert request [%v] err: %v", request.RequestID, rErr)
+		}
+		return nil, err
+	}
+	friendData, err := model.GetFriendDataByIDs(request.To, request.From)
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+		return nil, err
+	}
+	s.InvokeTarget(EventFriendRequestAccepted, request, request.From)
+	s.spawn(func() { s.PushFriendAdded(request.From, request.To) })
+	return friendData, nil
+}
+
+// RejectFriendRequest 拒绝申请
+func (s *Server) RejectFriendRequest(uid, requestID string) (*dao.FriendRequest, error) {
+	request, err := s.handleFriendRequest(uid, requestID, dao.FriendRequestRejected)
+	if err != nil {
+		return nil, err
+	}
+	s.InvokeTarget(EventFriendRequestRejected, request, request.From)
+	return request, nil
+}
+
+// CancelFriendRequest 发送方撤回申请
+func (s *Server) CancelFriendRequest(uid, requestID string) (*dao.FriendRequest, error) {
+	request, err := s.handleFriendRequest(uid, requestID, dao.FriendRequestCanceled)
+	if err != nil {
+		return nil, err
+	}
+	s.InvokeTarget(EventFriendRequestCanceled, request, request.To)
+	return request, nil
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"framework/api"
+	"framework/logger"
+	"github.com/gin-gonic/gin"
+	"logic/dao"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// GateHeader gate 转发请求时携带的节点名, 与 presence.nodes 配置中的键对应
+	GateHeader = "X-Gate-Node"
+	// GateSecretHeader gate 与 logic 共享的密钥, 与 presence.gateSecret 对应
+	GateSecretHeader = "X-Gate-Secret"
+)
+
+// gateClient 直接调用配置中的 gate 节点, 未配置的节点走默认 broker
+type gateClient struct {
+	nodes  map[string]string
+	secret string
+	client *http.Client
+}
+
+func newGateClient(nodes map[string]string, secret string, timeout time.Duration) *gateClient {
+	return &gateClient{nodes: nodes, secret: secret, client: &http.Client{Timeout: timeout}}
+}
+
+var ErrGateUnauthenticated = NewCodeError(ErrorCodeUnauthenticated, "gate secret is missing or does not match")
+
+// authenticate 校验 gate 上报连接变化时携带的密钥, 未配置 presence.gateSecret 时一律拒绝
+func (g *gateClient) authenticate(c *gin.Context) error {
+	if len(g.secret) == 0 || subtle.ConstantTimeCompare([]byte(c.GetHeader(GateSecretHeader)), []byte(g.secret)) != 1 {
+		return ErrGateUnauthenticated
+	}
+	return nil
+}
+
+// trusted 只采用配置中存在且密钥匹配的节点名, 其余连接记在默认 gate 上由 broker 投递
+func (g *gateClient) trusted(c *gin.Context) string {
+	gate := c.GetHeader(GateHeader)
+	if !g.has(gate) {
+		return ""
+	}
+	if len(g.secret) > 0 && subtle.ConstantTimeCompare([]byte(c.GetHeader(GateSecretHeader)), []byte(g.secret)) != 1 {
+		logger.Warn("Logic.gate %v presented an invalid secret", gate)
+		return ""
+	}
+	return gate
+}
+
+func (g *gateClient) has(gate string) bool {
+	_, ok := g.nodes[gate]
+	return ok
+}
+
+func (g *gateClient) invoke(gate string, iR *api.InvokeRequest) error {
+	raw, err := json.Marshal(iR)
+	if err != nil {
+		return err
+	}
+	resp, err := g.client.Post(g.nodes[gate], "application/json", bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("gate %v responded %v", gate, resp.Status)
+	}
+	return nil
+}
+
+// registerGate 记录用户所在的 gate, 返回登记前后用户的连接总数
+// 未携带可信 GateHeader 的连接记在默认 gate 上
+func (s *Server) registerGate(uid, gate, connID string) (int64, int64, error) {
+	if len(gate) == 0 {
+		gate = defaultGate
+	}
+	ttl := time.Duration(s.logicCfg.Presence.TTL) * time.Second
+	return dao.RegisterGate(uid, gate, connID, ttl)
+}
+
+// unregisterGate gate 上的一个连接断开, 返回剩余连接总数
+func (s *Server) unregisterGate(uid, gate, connID string) (int64, error) {
+	if len(gate) == 0 {
+		gate = defaultGate
+	}
+	return dao.UnregisterGate(uid, gate, connID)
+}
+
+// dropGate 重试用尽仍推送失败的 gate 视为已经失效, 移除这些用户在该 gate 上的连接记录
+// 之后的推送改走其它 gate 或默认 broker, 失去全部连接的用户按下线处理
+func (s *Server) dropGate(gate string, uids []string) {
+	if gate == defaultGate {
+		return
+	}
+	offline, err := dao.DropGate(gate, uids...)
+	if err != nil {
+		logger.Error("Logic.dropGate %v err: %v", gate, err)
+		return
+	}
+	logger.Warn("Logic.dropGate %v for %v users, %v now offline", gate, len(uids), len(offline))
+	for _, uid := range offline {
+		s.markOffline(uid)
+	}
+}
+
+// groupTargetsByGate 按所在 gate 分组, 查不到连接的用户交给默认 broker
+// 同一用户连接在多个 gate 时每个 gate 都会收到
+func (s *Server) groupTargetsByGate(targets []string) map[string][]string {
+	groups := make(map[string][]string)
+	if len(targets) == 0 {
+		return groups
+	}
+	gates, err := dao.GetUserGates(targets...)
+	if err != nil {
+		logger.Error("Logic.groupTargetsByGate get gates err: %v", err)
+		groups[defaultGate] = targets
+		return groups
+	}
+	for _, target := range targets {
+		userGates, ok := gates[target]
+		if !ok {
+			groups[defaultGate] = append(groups[defaultGate], target)
+			continue
+		}
+		for _, gate := range userGates {
+			groups[gate] = append(groups[gate], target)
+		}
+	}
+	return groups
+}
+
+// invokeGates 每个 gate 发送一次, 返回第一个失败的错误
+func (s *Server) invokeGates(event string, data interface{}, targets []string) error {
+	groups := s.groupTargetsByGate(targets)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for gate, gateTargets := range groups {
+		wg.Add(1)
+		go func(gate string, gateTargets []string) {
+			defer wg.Done()
+			iR := &api.InvokeRequest{
+				Event:   event,
+				Targets: gateTargets,
+				Data:    data,
+			}
+			if err := s.invoker.invoke(gate, iR); err != nil {
+				logger.Error("Logic.InvokeTarget Error: err: %v gate: %v, event:%v, target: %v", err, gate, event, gateTargets)
+				if err != ErrInvokeAbort {
+					s.dropGate(gate, gateTargets)
+				}
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(gate, gateTargets)
+	}
+	wg.Wait()
+	return firstErr
+}
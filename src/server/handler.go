@@ -6,23 +6,39 @@ import (
 	"framework/db"
 	"framework/logger"
 	"github.com/gin-gonic/gin"
+	"logic/dao"
 	"net/http"
 )
 
 // Chat 推送信息
 func (s *Server) Chat(c *gin.Context) {
-	cR := &api.ChatRequest{}
+	cR := &ChatRequest{}
 	err := c.BindJSON(cR)
 	if err != nil {
 		logger.Error("Logic.Auth "+api.UnmarshalJsonError, err)
 		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
 		return
 	}
-	msg := model.ChatMessageFrom(cR.From, cR.To, cR.Content, cR.Type, cR.Height, cR.Width, cR.Size, cR.FileName)
-
-	go s.PushChatMessage(msg)
-	go s.Produce(msg)
-	c.JSON(http.StatusOK, api.NewSuccessResponse(nil))
+	msg := dao.NewChatMessage(model.ChatMessageFrom(cR.From, cR.To, cR.Content, cR.Type, cR.Height, cR.Width, cR.Size, cR.FileName))
+	msg.ClientMsgID = cR.ClientMsgID
+	if err = s.AttachReply(msg, cR.ReplyTo, cR.ThreadRoot); err != nil {
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	if err = s.AttachMentions(msg, cR.Mentions, cR.MentionAll); err != nil {
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	original, err := s.SendChatMessageOnce(msg)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	resp := &ChatResponse{MessageID: msg.MessageID, Seq: msg.Seq, ClientMsgID: msg.ClientMsgID}
+	if original != nil {
+		resp = &ChatResponse{MessageID: original.MessageID, Seq: original.Seq, ClientMsgID: original.ClientMsgID, Duplicate: true, Message: original}
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(resp))
 }
 
 // GetUserInfo 获取用户信息
@@ -56,6 +72,7 @@ func (s *Server) Auth(c *gin.Context) {
 	if err != nil {
 		if db.IsNotExistError(err) {
 			// token expired
+			s.deleteSession(aR.Token)
 			c.AbortWithStatusJSON(http.StatusOK, api.TokenInvaildResp)
 			return
 		}
@@ -63,14 +80,36 @@ func (s *Server) Auth(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
 		return
 	}
+	// 连接由 gate 通过 connect 登记, 重复 Auth (如刷新 token) 不再增加连接数
+	s.saveSession(aR.Token, user.UID)
 	defer func(uid string) {
 		// Auth success then push load data
 		logger.Debug("Logic.Auth defer. uid: %v", uid)
-		go s.PushLoadData(uid)
+		s.PushLoadData(uid)
 	}(user.UID)
 	c.JSON(http.StatusOK, api.NewSuccessResponse(user))
 }
 
+// Logout 登出时移除 token 的会话缓存, 连接由 gate 另行通知断开
+func (s *Server) Logout(c *gin.Context) {
+	token, err := requestToken(c)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	if len(token) == 0 {
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(ErrUnauthenticated))
+		return
+	}
+	if err = dao.DeleteSession(token); err != nil {
+		logger.Error("Logic.Logout delete session err: %v", err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(nil))
+}
+
 // Load 推送初始化信息
 func (s *Server) Load(c *gin.Context) {
 	lR := &api.LoadRequest{}
@@ -80,32 +119,82 @@ func (s *Server) Load(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
 		return
 	}
-	go s.PushLoadData(lR.UID)
+	s.PushLoadData(lR.UID)
 	c.JSON(http.StatusOK, api.NewSuccessResponse(nil))
 }
 
-// AddFriend 添加好友
+// AddFriend 发送好友申请
 func (s *Server) AddFriend(c *gin.Context) {
-	fR := &api.FriendRequest{}
+	fR := &AddFriendRequest{}
 	err := c.BindJSON(fR)
 	if err != nil {
 		logger.Error(api.UnmarshalJsonError, err)
 		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
 		return
 	}
-	friendData, err := s.AddThenGetFriendData(fR.FriendA, fR.FriendB)
+	request, friendData, err := s.SendFriendRequest(fR.FriendA, fR.FriendB, fR.Greeting)
 	if err != nil {
 		logger.Error("Logic.AddFriend failed. err: %v", err)
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(&AddFriendResponse{Request: request, Friend: friendData}))
+}
+
+// AcceptFriend 接受好友申请
+func (s *Server) AcceptFriend(c *gin.Context) {
+	fR := &FriendRequestAction{}
+	err := c.BindJSON(fR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
 		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
 		return
 	}
-	defer func() {
-		go s.PushLoadData(fR.FriendA)
-		go s.PushLoadData(fR.FriendB)
-	}()
+	friendData, err := s.AcceptFriendRequest(fR.UID, fR.RequestID)
+	if err != nil {
+		logger.Error("Logic.AcceptFriend failed. err: %v", err)
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
 	c.JSON(http.StatusOK, api.NewSuccessResponse(friendData))
 }
 
+// RejectFriend 拒绝好友申请
+func (s *Server) RejectFriend(c *gin.Context) {
+	fR := &FriendRequestAction{}
+	err := c.BindJSON(fR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	request, err := s.RejectFriendRequest(fR.UID, fR.RequestID)
+	if err != nil {
+		logger.Error("Logic.RejectFriend failed. err: %v", err)
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(request))
+}
+
+// CancelFriend 撤回好友申请
+func (s *Server) CancelFriend(c *gin.Context) {
+	fR := &FriendRequestAction{}
+	err := c.BindJSON(fR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	request, err := s.CancelFriendRequest(fR.UID, fR.RequestID)
+	if err != nil {
+		logger.Error("Logic.CancelFriend failed. err: %v", err)
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(request))
+}
+
 // DeleteFriend 删除好友
 func (s *Server) DeleteFriend(c *gin.Context) {
 	fR := &api.FriendRequest{}
@@ -122,8 +211,7 @@ func (s *Server) DeleteFriend(c *gin.Context) {
 		return
 	}
 	defer func() {
-		go s.PushLoadData(fR.FriendA)
-		go s.PushLoadData(fR.FriendB)
+		s.spawn(func() { s.PushFriendDeleted(fR.FriendA, fR.FriendB) })
 	}()
 	c.JSON(http.StatusOK, api.NewSuccessResponse(friend))
 }
@@ -156,16 +244,18 @@ func (s *Server) JoinGroup(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
 		return
 	}
-	groupData, err := s.JoinAndGetGroupData(gR.UID, gR.GroupID)
+	groupData, request, err := s.RequestJoinGroup(gR.UID, gR.GroupID)
 	if err != nil {
 		logger.Error(api.UnmarshalJsonError, err)
-		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	if request != nil {
+		c.JSON(http.StatusOK, api.NewSuccessResponse(&JoinGroupResponse{Pending: true, Request: request}))
 		return
 	}
 	defer func() {
-		for _, member := range groupData.Members {
-			go s.PushLoadData(member.UID)
-		}
+		s.spawn(func() { s.PushMembersJoined(gR.GroupID, gR.UID) })
 	}()
 	c.JSON(http.StatusOK, api.NewSuccessResponse(groupData))
 }
@@ -181,83 +271,77 @@ func (s *Server) LeaveGroup(c *gin.Context) {
 	}
 	gUser, err := s.LeaveAndGetGroupUser(gR.UID, gR.GroupID)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
 		return
 	}
-	defer func(groupID string) {
-		uids, err := model.GetUserIDsByGroupID(groupID)
-		if err != nil {
-			logger.Error("Logic.PushLoadData After LeaveGroup Failed. err: %v", err)
-			return
-		}
-		for _, uid := range uids {
-			go s.PushLoadData(uid)
-		}
-	}(gR.GroupID)
+	defer func(groupID, uid string) {
+		s.spawn(func() { s.PushMemberLeft(groupID, uid) })
+	}(gR.GroupID, gR.UID)
 	c.JSON(http.StatusOK, api.NewSuccessResponse(gUser))
 }
 
 // FindUser 模糊搜索用户
 func (s *Server) FindUser(c *gin.Context) {
-	fUR := &api.FindRequest{}
+	fUR := &FindRequest{}
 	err := c.BindJSON(fUR)
 	if nil != err {
 		logger.Error(api.UnmarshalJsonError, err)
 		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
 		return
 	}
-	users, err := model.FindUsersByAccount(fUR.Account)
+	if caller := Caller(c); len(caller) > 0 {
+		fUR.UID = caller
+	}
+	resp, err := s.FindUsersRanked(fUR)
 	if nil != err {
-		logger.Error(api.UnmarshalJsonError, err)
-		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		logger.Error("Logic.FindUser err: %v", err)
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
 		return
 	}
-	c.JSON(http.StatusOK, api.NewSuccessResponse(users))
+	if !fUR.paged() {
+		c.JSON(http.StatusOK, api.NewSuccessResponse(resp.Users))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(resp))
 }
 
 // FindGroup 模糊搜索群组
 func (s *Server) FindGroup(c *gin.Context) {
-	fUR := &api.FindRequest{}
+	fUR := &FindRequest{}
 	err := c.BindJSON(fUR)
 	if nil != err {
 		logger.Error(api.UnmarshalJsonError, err)
 		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
 		return
 	}
-	groups, err := model.FindGroupsByGroupName(fUR.GroupName)
+	resp, err := s.FindGroupsRanked(fUR)
 	if nil != err {
-		logger.Error(api.UnmarshalJsonError, err)
-		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		logger.Error("Logic.FindGroup err: %v", err)
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	if !fUR.paged() {
+		c.JSON(http.StatusOK, api.NewSuccessResponse(resp.Groups))
 		return
 	}
-	c.JSON(http.StatusOK, api.NewSuccessResponse(groups))
+	c.JSON(http.StatusOK, api.NewSuccessResponse(resp))
 }
 
 // InviteFriend 邀请好友进群
 func (s *Server) InviteFriend(c *gin.Context) {
-	iR := &api.InviteRequest{}
+	iR := &InviteRequest{}
 	err := c.BindJSON(iR)
 	if err != nil {
 		logger.Error(api.UnmarshalJsonError, err)
 		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
 		return
 	}
-	err = s.InviteFriendsToGroup(iR.Friends, iR.GroupID)
+	err = s.InviteFriendsToGroup(operator(c, iR.UID), iR.Friends, iR.GroupID)
 	if err != nil {
 		logger.Error("InviteFriendsToGroup err: %v", err)
-		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
 		return
 	}
-	defer func(groupID string) {
-		uids, err := model.GetUserIDsByGroupID(groupID)
-		if err != nil {
-			logger.Error("Logic.PushLoadData After LeaveGroup Failed. err: %v", err)
-			return
-		}
-		for _, uid := range uids {
-			go s.PushLoadData(uid)
-		}
-	}(iR.GroupID)
 	c.JSON(http.StatusOK, api.NewSuccessResponse(nil))
 }
 
@@ -294,21 +378,15 @@ func (s *Server) UpdateUser(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
 		return
 	}
-	defer func(uid string) {
-		targets, err := model.GetAssociatedUIDsByUID(uid)
-		if err != nil {
-			return
-		}
-		for _, target := range targets {
-			go s.PushLoadData(target)
-		}
-	}(user.UID)
+	defer func(user *model.User) {
+		s.spawn(func() { s.PushUserUpdated(user) })
+	}(user)
 	c.JSON(http.StatusOK, api.NewSuccessResponse(user))
 }
 
 // UpdateGroup 更新群组信息
 func (s *Server) UpdateGroup(c *gin.Context) {
-	uR := &api.UpdateGroupRequest{}
+	uR := &UpdateGroupRequest{}
 	err := c.BindJSON(uR)
 	if err != nil {
 		logger.Error(api.UnmarshalJsonError, err)
@@ -316,21 +394,535 @@ func (s *Server) UpdateGroup(c *gin.Context) {
 		return
 	}
 	logger.Debug("Logic.UpdateGroup Request: [%v]", *uR)
-	group, err := s.UpdateGroupInfo(uR.GroupID, uR.GroupName, uR.GroupNotice)
+	group, err := s.UpdateGroupInfo(operator(c, uR.UID), uR.GroupID, uR.GroupName, uR.GroupNotice)
 	if nil != err {
 		logger.Error("UpdateGroupInfo err: %v", err)
-		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
 		return
 	}
 	defer func(group *model.Group) {
-		targets, err := model.GetUsersByGroup(group)
-		if err != nil {
-			return
-		}
-		for _, target := range targets {
-			go s.PushLoadData(target.UID)
-		}
+		s.spawn(func() { s.PushGroupUpdated(group) })
 	}(group)
 	c.JSON(http.StatusOK, api.NewSuccessResponse(group))
 
 }
+
+// PromoteAdmin 设置群管理员
+func (s *Server) PromoteAdmin(c *gin.Context) {
+	gR := &GroupMemberRequest{}
+	err := c.BindJSON(gR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	err = s.PromoteGroupAdmin(gR.UID, gR.GroupID, gR.Target)
+	if err != nil {
+		logger.Error("Logic.PromoteAdmin err: %v", err)
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(nil))
+}
+
+// DemoteAdmin 取消群管理员
+func (s *Server) DemoteAdmin(c *gin.Context) {
+	gR := &GroupMemberRequest{}
+	err := c.BindJSON(gR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	err = s.DemoteGroupAdmin(gR.UID, gR.GroupID, gR.Target)
+	if err != nil {
+		logger.Error("Logic.DemoteAdmin err: %v", err)
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(nil))
+}
+
+// TransferOwner 转让群主
+func (s *Server) TransferOwner(c *gin.Context) {
+	gR := &GroupMemberRequest{}
+	err := c.BindJSON(gR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	err = s.TransferGroupOwner(gR.UID, gR.GroupID, gR.Target)
+	if err != nil {
+		logger.Error("Logic.TransferOwner err: %v", err)
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(nil))
+}
+
+// KickMember 移出群成员并广播
+func (s *Server) KickMember(c *gin.Context) {
+	gR := &GroupMemberRequest{}
+	err := c.BindJSON(gR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	gUser, err := s.KickAndGetGroupUser(gR.UID, gR.GroupID, gR.Target)
+	if err != nil {
+		logger.Error("Logic.KickMember err: %v", err)
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	defer func(gR *GroupMemberRequest) {
+		s.spawn(func() {
+			s.PushGroupMemberRemoved(&GroupMemberRemoved{GroupID: gR.GroupID, UID: gR.Target, Operator: gR.UID, Reason: RemoveReasonKick})
+		})
+	}(gR)
+	c.JSON(http.StatusOK, api.NewSuccessResponse(gUser))
+}
+
+// BanMember 禁止用户加入群组, 在群内时一并移出
+func (s *Server) BanMember(c *gin.Context) {
+	gR := &GroupMemberRequest{}
+	err := c.BindJSON(gR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	gUser, err := s.BanGroupUser(gR.UID, gR.GroupID, gR.Target)
+	if err != nil {
+		logger.Error("Logic.BanMember err: %v", err)
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	if gUser != nil {
+		defer func(gR *GroupMemberRequest) {
+			s.spawn(func() {
+				s.PushGroupMemberRemoved(&GroupMemberRemoved{GroupID: gR.GroupID, UID: gR.Target, Operator: gR.UID, Reason: RemoveReasonBan})
+			})
+		}(gR)
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(gUser))
+}
+
+// UnbanMember 解除群组禁止
+func (s *Server) UnbanMember(c *gin.Context) {
+	gR := &GroupMemberRequest{}
+	err := c.BindJSON(gR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	err = s.UnbanGroupUser(gR.UID, gR.GroupID, gR.Target)
+	if err != nil {
+		logger.Error("Logic.UnbanMember err: %v", err)
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(nil))
+}
+
+// Block 屏蔽用户
+func (s *Server) Block(c *gin.Context) {
+	bR := &BlockRequest{}
+	err := c.BindJSON(bR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	err = s.BlockUser(bR.UID, bR.Target)
+	if err != nil {
+		logger.Error("Logic.Block err: %v", err)
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	defer func(uid string) {
+		s.PushLoadData(uid)
+	}(bR.UID)
+	c.JSON(http.StatusOK, api.NewSuccessResponse(nil))
+}
+
+// Unblock 取消屏蔽
+func (s *Server) Unblock(c *gin.Context) {
+	bR := &BlockRequest{}
+	err := c.BindJSON(bR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	err = s.UnblockUser(bR.UID, bR.Target)
+	if err != nil {
+		logger.Error("Logic.Unblock err: %v", err)
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	defer func(uid string) {
+		s.PushLoadData(uid)
+	}(bR.UID)
+	c.JSON(http.StatusOK, api.NewSuccessResponse(nil))
+}
+
+// BlockList 获取屏蔽列表
+func (s *Server) BlockList(c *gin.Context) {
+	bR := &BlockRequest{}
+	err := c.BindJSON(bR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	uids, err := s.GetBlockList(bR.UID)
+	if err != nil {
+		logger.Error("Logic.BlockList err: %v", err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(uids))
+}
+
+// Sync 拉取指定版本之后的增量变更
+func (s *Server) Sync(c *gin.Context) {
+	sR := &SyncRequest{}
+	err := c.BindJSON(sR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	resp, err := s.GetChangesSince(sR.UID, sR.Version)
+	if err != nil {
+		logger.Error("Logic.Sync err: %v", err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(resp))
+}
+
+// MarkRead 标记房间已读
+func (s *Server) MarkRead(c *gin.Context) {
+	mR := &MarkReadRequest{}
+	err := c.BindJSON(mR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	receipt, err := s.MarkRoomRead(mR.UID, mR.RoomID, mR.Seq)
+	if err != nil {
+		logger.Error("Logic.MarkRead err: %v", err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(receipt))
+}
+
+// Ack 客户端确认收到消息
+func (s *Server) Ack(c *gin.Context) {
+	aR := &AckRequest{}
+	err := c.BindJSON(aR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	result, err := s.AckMessages(aR.UID, aR.MessageIDs)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(result))
+}
+
+// DeadLetters 查询推送失败的死信
+func (s *Server) DeadLetters(c *gin.Context) {
+	dR := &DeadLettersRequest{}
+	err := c.BindJSON(dR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	letters, err := s.FindDeadLetters(dR.Event, dR.Limit)
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(letters))
+}
+
+// ReplayDeadLetters 重放死信
+func (s *Server) ReplayDeadLetters(c *gin.Context) {
+	rR := &ReplayDeadLettersRequest{}
+	err := c.BindJSON(rR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	result, err := s.ReplayDeadLetterRequests(rR.IDs)
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(result))
+}
+
+// Connect gate 上已鉴权的连接建立, 例如复用会话的重连
+func (s *Server) Connect(c *gin.Context) {
+	cR := &ConnectionRequest{}
+	err := c.BindJSON(cR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	s.ConnectUser(cR.UID, s.gates.trusted(c), cR.ConnID)
+	s.PushConnectData(cR.UID)
+	c.JSON(http.StatusOK, api.NewSuccessResponse(nil))
+}
+
+// Disconnect 用户在 gate 上的连接断开
+func (s *Server) Disconnect(c *gin.Context) {
+	cR := &ConnectionRequest{}
+	err := c.BindJSON(cR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	if err = s.DisconnectUser(cR.UID, s.gates.trusted(c), cR.ConnID); err != nil {
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(nil))
+}
+
+// SetPresence 设置是否对好友隐藏在线状态
+func (s *Server) SetPresence(c *gin.Context) {
+	pR := &PresenceRequest{}
+	err := c.BindJSON(pR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	if err = s.SetPresenceHidden(pR.UID, pR.Hidden); err != nil {
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(nil))
+}
+
+// Typing 转发输入状态
+func (s *Server) Typing(c *gin.Context) {
+	tR := &TypingRequest{}
+	err := c.BindJSON(tR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	relayed, err := s.RelayTyping(tR.From, tR.To, tR.Typing)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(&TypingResponse{Relayed: relayed}))
+}
+
+// Recall 撤回消息
+func (s *Server) Recall(c *gin.Context) {
+	mR := &MessageRequest{}
+	err := c.BindJSON(mR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	recalled, err := s.RecallMessage(mR.UID, mR.MessageID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(recalled))
+}
+
+// Edit 编辑消息
+func (s *Server) Edit(c *gin.Context) {
+	eR := &EditMessageRequest{}
+	err := c.BindJSON(eR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	edited, err := s.EditMessage(eR.UID, eR.MessageID, eR.Content)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(edited))
+}
+
+// DeleteMessage 仅对自己删除消息
+func (s *Server) DeleteMessage(c *gin.Context) {
+	mR := &MessageRequest{}
+	err := c.BindJSON(mR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	if err = s.DeleteMessageForMe(mR.UID, mR.MessageID); err != nil {
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(nil))
+}
+
+// MessageEdits 查看消息的编辑历史
+func (s *Server) MessageEdits(c *gin.Context) {
+	mR := &MessageRequest{}
+	err := c.BindJSON(mR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	edits, err := s.GetMessageEdits(mR.UID, mR.MessageID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(edits))
+}
+
+// PullThread 分页加载话题内的回复
+func (s *Server) PullThread(c *gin.Context) {
+	pR := &PullThreadRequest{}
+	err := c.BindJSON(pR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	messages, err := s.PullThreadByPage(pR.UID, pR.ThreadRoot, pR.Current, pR.PageSize)
+	if err != nil {
+		logger.Error("PullThread err: %v", err)
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(messages))
+}
+
+// PullMessageCursor 游标分页加载消息
+func (s *Server) PullMessageCursor(c *gin.Context) {
+	pR := &CursorPullRequest{}
+	err := c.BindJSON(pR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	page, err := s.PullMessageByCursor(pR)
+	if err != nil {
+		logger.Error("PullMessageCursor err: %v", err)
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(page))
+}
+
+// SearchMessage 搜索聊天记录
+func (s *Server) SearchMessage(c *gin.Context) {
+	sR := &SearchMessageRequest{}
+	err := c.BindJSON(sR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	resp, err := s.SearchMessages(sR)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(resp))
+}
+
+// UpdateGroupSetting 修改群组的可见性与加入方式
+func (s *Server) UpdateGroupSetting(c *gin.Context) {
+	gR := &GroupSettingRequest{}
+	err := c.BindJSON(gR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	setting, err := s.SaveGroupSetting(gR)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(setting))
+}
+
+// ApproveJoin 通过入群申请
+func (s *Server) ApproveJoin(c *gin.Context) {
+	jR := &JoinRequestAction{}
+	err := c.BindJSON(jR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	request, err := s.ApproveJoinRequest(jR.UID, jR.RequestID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(request))
+}
+
+// RejectJoin 拒绝入群申请
+func (s *Server) RejectJoin(c *gin.Context) {
+	jR := &JoinRequestAction{}
+	err := c.BindJSON(jR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	request, err := s.RejectJoinRequest(jR.UID, jR.RequestID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(request))
+}
+
+// JoinRequests 查看群组待审批的入群申请
+func (s *Server) JoinRequests(c *gin.Context) {
+	gR := &api.GroupRequest{}
+	err := c.BindJSON(gR)
+	if err != nil {
+		logger.Error(api.UnmarshalJsonError, err)
+		c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(err))
+		return
+	}
+	requests, err := s.GetJoinRequests(gR.UID, gR.GroupID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusOK, NewErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, api.NewSuccessResponse(requests))
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"framework/api"
+	"framework/broker"
+	"framework/logger"
+	"logic/conf"
+	"logic/dao"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// defaultGate 只配置了一个 broker 时所有目标都属于该 gate
+const defaultGate = "default"
+
+var (
+	ErrCircuitOpen = errors.New("gate circuit breaker is open")
+	ErrInvokeAbort = errors.New("invoke retry aborted by shutdown")
+)
+
+var (
+	invokeFailed       = expvar.NewInt("logic.invoke.failed")
+	invokeRetried      = expvar.NewInt("logic.invoke.retried")
+	invokeRejected     = expvar.NewInt("logic.invoke.rejected")
+	invokeDeadLettered = expvar.NewInt("logic.invoke.deadLettered")
+)
+
+// invoker 带重试与熔断的 broker 调用, 重试耗尽后写入死信
+type invoker struct {
+	broker broker.LogicBroker
+	gates  *gateClient
+	cfg    conf.InvokeConfig
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newInvoker(b broker.LogicBroker, gates *gateClient, cfg conf.InvokeConfig) *invoker {
+	return &invoker{
+		broker:   b,
+		gates:    gates,
+		cfg:      cfg,
+		breakers: make(map[string]*circuitBreaker),
+		done:     make(chan struct{}),
+	}
+}
+
+func (i *invoker) breaker(gate string) *circuitBreaker {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	b, ok := i.breakers[gate]
+	if !ok {
+		b = newCircuitBreaker(i.cfg.BreakerThreshold, time.Duration(i.cfg.BreakerCooldown)*time.Millisecond)
+		i.breakers[gate] = b
+	}
+	return b
+}
+
+// backoff 第 attempt 次重试前的等待时间, 指数增长并加入 ±jitter 的随机抖动
+func (i *invoker) backoff(attempt int) time.Duration {
+	d := time.Duration(i.cfg.BaseDelay) * time.Millisecond << uint(attempt-1)
+	max := time.Duration(i.cfg.MaxDelay) * time.Millisecond
+	if d > max || d <= 0 {
+		d = max
+	}
+	if i.cfg.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * i.cfg.Jitter * float64(d))
+	}
+	return d
+}
+
+func (i *invoker) dispatch(gate string, iR *api.InvokeRequest) error {
+	if i.gates.has(gate) {
+		return i.gates.invoke(gate, iR)
+	}
+	_, err := i.broker.Invoke(iR)
+	return err
+}
+
+// send 按重试策略发送, 返回最后一次的错误与尝试次数
+func (i *invoker) send(gate string, iR *api.InvokeRequest) (int, error) {
+	attempts := i.cfg.MaxAttempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	b := i.breaker(gate)
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			invokeRetried.Add(1)
+			select {
+			case <-time.After(i.backoff(attempt - 1)):
+			case <-i.done:
+				return attempt - 1, ErrInvokeAbort
+			}
+		}
+		if !b.allow() {
+			invokeRejected.Add(1)
+			err = ErrCircuitOpen
+			continue
+		}
+		if err = i.dispatch(gate, iR); err == nil {
+			b.success()
+			return attempt, nil
+		}
+		b.failure()
+		logger.Warn("Logic.invoke gate: %v, event: %v, attempt %v/%v err: %v", gate, iR.Event, attempt, attempts, err)
+	}
+	return attempts, err
+}
+
+// invoke 发送失败时写入死信
+func (i *invoker) invoke(gate string, iR *api.InvokeRequest) error {
+	attempts, err := i.send(gate, iR)
+	if err == nil {
+		return nil
+	}
+	invokeFailed.Add(1)
+	i.deadLetter(gate, iR, attempts, err)
+	return err
+}
+
+func (i *invoker) deadLetter(gate string, iR *api.InvokeRequest, attempts int, cause error) {
+	raw, err := json.Marshal(iR.Data)
+	if err != nil {
+		logger.Error("Logic.invoke marshal dead letter err: %v", err)
+		return
+	}
+	now := time.Now()
+	letter := &dao.DeadLetter{
+		ID:         dao.NewMessageID(),
+		Gate:       gate,
+		Event:      iR.Event,
+		Targets:    iR.Targets,
+		Data:       string(raw),
+		Error:      cause.Error(),
+		Attempts:   attempts,
+		CreateTime: now,
+		UpdateTime: now,
+	}
+	if err = dao.InsertDeadLetter(letter); err != nil {
+		logger.Error("Logic.invoke insert dead letter err: %v, event: %v, target: %v", err, iR.Event, iR.Targets)
+		return
+	}
+	invokeDeadLettered.Add(1)
+}
+
+// stop 中断所有等待中的重试, 剩余请求直接写入死信
+func (i *invoker) stop() {
+	i.stopOnce.Do(func() {
+		close(i.done)
+	})
+}
+
+// FindDeadLetters 查询死信, limit 默认 50
+func (s *Server) FindDeadLetters(event string, limit int64) ([]*dao.DeadLetter, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	return dao.GetDeadLetters(event, limit)
+}
+
+// ReplayDeadLetterRequests 按写入顺序同步重放, 成功的死信被删除, 失败的保留并记录原因
+func (s *Server) ReplayDeadLetterRequests(ids []string) (*ReplayResult, error) {
+	letters, err := dao.GetDeadLettersByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	result := &ReplayResult{Replayed: make([]string, 0, len(letters)), Failed: make(map[string]string)}
+	for _, letter := range letters {
+		iR := &api.InvokeRequest{
+			Event:   letter.Event,
+			Targets: letter.Targets,
+			Data:    json.RawMessage(letter.Data),
+		}
+		if _, err = s.invoker.send(letter.Gate, iR); err != nil {
+			result.Failed[letter.ID] = err.Error()
+			if err = dao.MarkDeadLetterReplayFailed(letter.ID, err); err != nil {
+				logger.Error("Logic.ReplayDeadLetters mark failed err: %v", err)
+			}
+			continue
+		}
+		if err = dao.DeleteDeadLetter(letter.ID); err != nil {
+			logger.Error("Logic.ReplayDeadLetters delete err: %v", err)
+		}
+		result.Replayed = append(result.Replayed, letter.ID)
+	}
+	logger.Info("Logic.ReplayDeadLetters replayed: %v, failed: %v", len(result.Replayed), len(result.Failed))
+	return result, nil
+}
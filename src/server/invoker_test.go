@@ -0,0 +1,131 @@
+package server
+
+import (
+	"framework/api"
+	"logic/conf"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	const cooldown = 20 * time.Millisecond
+	b := newCircuitBreaker(2, cooldown)
+	if !b.allow() {
+		t.Fatal("new breaker rejected a request")
+	}
+	b.failure()
+	if !b.allow() {
+		t.Fatal("breaker opened below the threshold")
+	}
+	b.failure()
+	if b.allow() {
+		t.Fatal("breaker still closed after threshold failures")
+	}
+
+	// 冷却后只放行一个探测请求, 探测失败重新熔断
+	time.Sleep(cooldown)
+	if !b.allow() {
+		t.Fatal("probe rejected after cooldown")
+	}
+	if b.allow() {
+		t.Fatal("second request allowed while probing")
+	}
+	b.failure()
+	if b.allow() {
+		t.Fatal("breaker closed after a failed probe")
+	}
+
+	// 探测成功后恢复, 失败计数清零
+	time.Sleep(cooldown)
+	if !b.allow() {
+		t.Fatal("probe rejected after cooldown")
+	}
+	b.success()
+	b.failure()
+	if !b.allow() || !b.allow() {
+		t.Fatal("breaker did not close after a successful probe")
+	}
+
+	disabled := newCircuitBreaker(0, cooldown)
+	for i := 0; i < 5; i++ {
+		disabled.failure()
+	}
+	if !disabled.allow() {
+		t.Fatal("breaker with threshold 0 rejected a request")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	i := newInvoker(nil, nil, conf.InvokeConfig{BaseDelay: 10, MaxDelay: 50})
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 10 * time.Millisecond},
+		{2, 20 * time.Millisecond},
+		{3, 40 * time.Millisecond},
+		{4, 50 * time.Millisecond},
+		// 位移溢出时同样取上限
+		{80, 50 * time.Millisecond},
+	}
+	for _, c := range cases {
+		if got := i.backoff(c.attempt); got != c.want {
+			t.Errorf("backoff(%v) = %v, want %v", c.attempt, got, c.want)
+		}
+	}
+
+	i.cfg.Jitter = 0.5
+	for n := 0; n < 100; n++ {
+		if got := i.backoff(2); got < 10*time.Millisecond || got > 30*time.Millisecond {
+			t.Fatalf("backoff(2) with jitter 0.5 = %v, want within 10ms..30ms", got)
+		}
+	}
+}
+
+// newTestGate 启动一个 gate, 前 failures 次请求返回 500
+func newTestGate(t *testing.T, failures int32) (*gateClient, *int32) {
+	t.Helper()
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= failures {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return newGateClient(map[string]string{"g1": srv.URL}, "", time.Second), &calls
+}
+
+func TestInvokerSend(t *testing.T) {
+	iR := &api.InvokeRequest{Event: "event", Targets: []string{"u1"}}
+
+	t.Run("retries until success", func(t *testing.T) {
+		gates, calls := newTestGate(t, 2)
+		i := newInvoker(nil, gates, conf.InvokeConfig{MaxAttempts: 3, BaseDelay: 1, MaxDelay: 5})
+		attempts, err := i.send("g1", iR)
+		if err != nil || attempts != 3 || atomic.LoadInt32(calls) != 3 {
+			t.Fatalf("attempts = %v, calls = %v, err = %v", attempts, atomic.LoadInt32(calls), err)
+		}
+	})
+
+	t.Run("breaker rejects remaining attempts", func(t *testing.T) {
+		gates, calls := newTestGate(t, 100)
+		i := newInvoker(nil, gates, conf.InvokeConfig{MaxAttempts: 3, BaseDelay: 1, MaxDelay: 5, BreakerThreshold: 1, BreakerCooldown: 60000})
+		attempts, err := i.send("g1", iR)
+		if err != ErrCircuitOpen || attempts != 3 || atomic.LoadInt32(calls) != 1 {
+			t.Fatalf("attempts = %v, calls = %v, err = %v", attempts, atomic.LoadInt32(calls), err)
+		}
+	})
+
+	t.Run("stop aborts waiting retries", func(t *testing.T) {
+		gates, calls := newTestGate(t, 100)
+		i := newInvoker(nil, gates, conf.InvokeConfig{MaxAttempts: 3, BaseDelay: 60000, MaxDelay: 60000})
+		time.AfterFunc(20*time.Millisecond, i.stop)
+		attempts, err := i.send("g1", iR)
+		if err != ErrInvokeAbort || attempts != 1 || atomic.LoadInt32(calls) != 1 {
+			t.Fatalf("attempts = %v, calls = %v, err = %v", attempts, atomic.LoadInt32(calls), err)
+		}
+	})
+}
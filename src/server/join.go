@@ -0,0 +1,189 @@
+package server
+
+import (
+	"framework/api"
+	"framework/api/model"
+	"framework/logger"
+	"go.mongodb.org/mongo-driver/mongo"
+	"logic/dao"
+)
+
+var (
+	ErrGroupInviteOnly     = NewCodeError(ErrorCodeForbidden, "group can only be joined by invitation")
+	ErrInvalidJoinPolicy   = NewCodeError(ErrorCodeForbidden, "unknown group join policy")
+	ErrJoinRequestNotFound = NewCodeError(ErrorCodeForbidden, "group join request not found")
+	ErrJoinRequestHandled  = NewCodeError(ErrorCodeForbidden, "group join request has been handled")
+	ErrAlreadyGroupMember  = NewCodeError(ErrorCodeForbidden, "user is already a member of the group")
+)
+
+var joinPolicies = map[string]bool{
+	dao.GroupJoinOpen:       true,
+	dao.GroupJoinApproval:   true,
+	dao.GroupJoinInviteOnly: true,
+}
+
+// JoinGroupResponse 需要审批的群组返回待审批的申请, 其余情况与旧接口一样返回群组数据
+type JoinGroupResponse struct {
+	Pending bool                  `json:"pending"`
+	Request *dao.GroupJoinRequest `json:"request"`
+}
+
+// groupManagers 群主与管理员, 旧数据的群主按 Group.GroupAdmin 判断
+func (s *Server) groupManagers(groupID string) ([]string, error) {
+	uids, err := dao.GetGroupUIDsByRoles(groupID, RoleOwner, RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	group, err := model.GetGroupByGroupID(groupID)
+	if err != nil {
+		return nil, err
+	}
+	if len(group.GroupAdmin) > 0 && !containsString(uids, group.GroupAdmin) {
+		uids = append(uids, group.GroupAdmin)
+	}
+	return uids, nil
+}
+
+// RequestJoinGroup 按群组的加入方式处理 JoinGroup
+// 开放群组直接加入并返回群组数据; 需要审批的群组返回待审批的申请并通知管理员
+func (s *Server) RequestJoinGroup(uid, groupID string) (*model.GroupData, *dao.GroupJoinRequest, error) {
+	setting, err := dao.GetGroupSetting(groupID)
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+		return nil, nil, err
+	}
+	switch setting.JoinPolicy {
+	case dao.GroupJoinInviteOnly:
+		return nil, nil, ErrGroupInviteOnly
+	case dao.GroupJoinApproval:
+		request, err := s.createJoinRequest(uid, groupID)
+		return nil, request, err
+	}
+	groupData, err := s.JoinAndGetGroupData(uid, groupID)
+	return groupData, nil, err
+}
+
+func (s *Server) createJoinRequest(uid, groupID string) (*dao.GroupJoinRequest, error) {
+	banned, err := dao.IsGroupBanned(groupID, uid)
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+		return nil, err
+	}
+	if banned {
+		return nil, ErrBannedFromGroup
+	}
+	member, err := s.isGroupMember(uid, groupID)
+	if err != nil {
+		return nil, err
+	}
+	if member {
+		return nil, ErrAlreadyGroupMember
+	}
+	request, err := dao.GetPendingGroupJoinRequest(groupID, uid)
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+		return nil, err
+	}
+	if request != nil {
+		return request, nil
+	}
+	request = dao.NewGroupJoinRequest(groupID, uid)
+	err = dao.InsertGroupJoinRequest(request)
+	if mongo.IsDuplicateKeyError(err) {
+		// 并发提交的另一条申请已经写入并通知了管理员, 返回那一条
+		request, err = dao.GetPendingGroupJoinRequest(groupID, uid)
+		if err == nil && request == nil {
+			err = ErrJoinRequestHandled
+		}
+		if err != nil {
+			logger.Error(api.MongoDBError, err)
+			return nil, err
+		}
+		return request, nil
+	}
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+		return nil, err
+	}
+	managers, err := s.groupManagers(groupID)
+	if err != nil {
+		logger.Error("Logic.createJoinRequest get managers err: %v", err)
+		return request, nil
+	}
+	s.InvokeTarget(EventGroupJoinRequested, request, managers...)
+	return request, nil
+}
+
+// handleJoinRequest 有邀请权限的成员审批申请
+func (s *Server) handleJoinRequest(operator, requestID, status string) (*dao.GroupJoinRequest, error) {
+	request, err := dao.GetGroupJoinRequest(requestID)
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+		return nil, err
+	}
+	if request == nil {
+		return nil, ErrJoinRequestNotFound
+	}
+	if _, err = s.checkGroupPermission(request.GroupID, operator, permInvite); err != nil {
+		return nil, err
+	}
+	request.Status = status
+	request.Operator = operator
+	updated, err := dao.UpdateGroupJoinRequest(request)
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+		return nil, err
+	}
+	if !updated {
+		return nil, ErrJoinRequestHandled
+	}
+	return request, nil
+}
+
+// ApproveJoinRequest 通过后申请人加入群组, 群组数据与成员变更通过增量同步下发
+// 申请人已经通过邀请等方式入群时只把申请标记为通过, 不重复加入
+// 加入失败时申请恢复为 pending, 可以重新审批
+func (s *Server) ApproveJoinRequest(operator, requestID string) (*dao.GroupJoinRequest, error) {
+	request, err := s.handleJoinRequest(operator, requestID, dao.GroupJoinApproved)
+	if err != nil {
+		return nil, err
+	}
+	member, err := s.isGroupMember(request.UID, request.GroupID)
+	if err != nil {
+		if rErr := dao.RevertGroupJoinRequest(request.RequestID, dao.GroupJoinApproved); rErr != nil {
+			logger.Error("Logic.ApproveJoinRequest revert request [%v] err: %v", request.RequestID, rErr)
+		}
+		return nil, err
+	}
+	if member {
+		s.InvokeTarget(EventGroupJoinApproved, request, request.UID)
+		return request, nil
+	}
+	if _, err = s.JoinAndGetGroupData(request.UID, request.GroupID); err != nil {
+		if rErr := dao.RevertGroupJoinRequest(request.RequestID, dao.GroupJoinApproved); rErr != nil {
+			logger.Error("Logic.ApproveJoinRequest revert request [%v] err: %v", request.RequestID, rErr)
+		}
+		return nil, err
+	}
+	s.InvokeTarget(EventGroupJoinApproved, request, request.UID)
+	s.spawn(func() { s.PushMembersJoined(request.GroupID, request.UID) })
+	return request, nil
+}
+
+// RejectJoinRequest 拒绝后通知申请人
+func (s *Server) RejectJoinRequest(operator, requestID string) (*dao.GroupJoinRequest, error) {
+	request, err := s.handleJoinRequest(operator, requestID, dao.GroupJoinRejected)
+	if err != nil {
+		return nil, err
+	}
+	s.InvokeTarget(EventGroupJoinRejected, request, request.UID)
+	return request, nil
+}
+
+// GetJoinRequests 有邀请权限的成员查看待审批的申请
+func (s *Server) GetJoinRequests(operator, groupID string) ([]*dao.GroupJoinRequest, error) {
+	if _, err := s.checkGroupPermission(groupID, operator, permInvite); err != nil {
+		return nil, err
+	}
+	return dao.GetPendingGroupJoinRequests(groupID)
+}
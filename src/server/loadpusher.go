@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"time"
+)
+
+var (
+	loadPushRequested = expvar.NewInt("logic.loadPush.requested")
+	loadPushCoalesced = expvar.NewInt("logic.loadPush.coalesced")
+	loadPushExecuted  = expvar.NewInt("logic.loadPush.executed")
+	loadPushDropped   = expvar.NewInt("logic.loadPush.dropped")
+	loadPushDeferred  = expvar.NewInt("logic.loadPush.deferred")
+)
+
+// loadPusher 按 UID 合并 delay 内的多次 PushLoadData, 由固定数量的 worker 执行
+// 合并的触发中任意一次要求补发收件箱, 执行时就补发
+// 每个 uid 同时最多有一个定时器, 队列满时定时器重新计时而不是阻塞等待
+type loadPusher struct {
+	delay time.Duration
+	push  func(uid string, flush bool)
+	mu    sync.Mutex
+	// pending 等待执行的 uid, 值为是否需要补发收件箱
+	pending map[string]bool
+	closed  bool
+	queue   chan string
+	wg      sync.WaitGroup
+}
+
+func newLoadPusher(delay time.Duration, workers, queueSize int, push func(uid string, flush bool)) *loadPusher {
+	if workers <= 0 {
+		workers = 1
+	}
+	p := &loadPusher{
+		delay:   delay,
+		push:    push,
+		pending: make(map[string]bool),
+		queue:   make(chan string, queueSize),
+	}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return p
+}
+
+// schedule uid 已在等待中时直接合并
+func (p *loadPusher) schedule(uid string, flush bool) {
+	loadPushRequested.Add(1)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		loadPushDropped.Add(1)
+		return
+	}
+	if pendingFlush, ok := p.pending[uid]; ok {
+		p.pending[uid] = pendingFlush || flush
+		loadPushCoalesced.Add(1)
+		return
+	}
+	p.pending[uid] = flush
+	time.AfterFunc(p.delay, func() { p.enqueue(uid) })
+}
+
+// enqueue 在锁内非阻塞入队, 与 stop 关闭队列互斥
+func (p *loadPusher) enqueue(uid string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		loadPushDropped.Add(1)
+		return
+	}
+	select {
+	case p.queue <- uid:
+	default:
+		// 队列已满, 保持 pending 继续合并新的触发, 下个周期再试
+		loadPushDeferred.Add(1)
+		time.AfterFunc(p.delay, func() { p.enqueue(uid) })
+	}
+}
+
+func (p *loadPusher) work() {
+	defer p.wg.Done()
+	for uid := range p.queue {
+		// 先取消标记, 执行期间的新触发会再排一次, 保证推送的是最新数据
+		p.mu.Lock()
+		flush := p.pending[uid]
+		delete(p.pending, uid)
+		p.mu.Unlock()
+		p.push(uid, flush)
+		loadPushExecuted.Add(1)
+	}
+}
+
+// stop 丢弃尚未到期的推送, 在 ctx 结束前等待已入队的推送完成
+func (p *loadPusher) stop(ctx context.Context) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.queue)
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
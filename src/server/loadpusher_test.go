@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type loadPush struct {
+	uid   string
+	flush bool
+	at    time.Time
+}
+
+// recordPushes 返回记录推送的 push 函数, 每次推送前等待 release 放行
+func recordPushes(release <-chan struct{}) (chan loadPush, func(uid string, flush bool)) {
+	pushes := make(chan loadPush, 16)
+	return pushes, func(uid string, flush bool) {
+		if release != nil {
+			<-release
+		}
+		pushes <- loadPush{uid: uid, flush: flush, at: time.Now()}
+	}
+}
+
+func nextPush(t *testing.T, pushes <-chan loadPush) loadPush {
+	t.Helper()
+	select {
+	case push := <-pushes:
+		return push
+	case <-time.After(time.Second):
+		t.Fatal("load push not executed")
+	}
+	return loadPush{}
+}
+
+func assertNoPush(t *testing.T, pushes <-chan loadPush, wait time.Duration) {
+	t.Helper()
+	select {
+	case push := <-pushes:
+		t.Fatalf("unexpected load push %+v", push)
+	case <-time.After(wait):
+	}
+}
+
+func TestLoadPusherCoalesces(t *testing.T) {
+	const delay = 30 * time.Millisecond
+	pushes, push := recordPushes(nil)
+	p := newLoadPusher(delay, 2, 16, push)
+	defer p.stop(context.Background())
+
+	coalesced := loadPushCoalesced.Value()
+	start := time.Now()
+	p.schedule("u1", false)
+	p.schedule("u1", true)
+	p.schedule("u1", false)
+	p.schedule("u2", false)
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		push := nextPush(t, pushes)
+		if push.at.Sub(start) < delay {
+			t.Fatalf("push for %v ran after %v, before the %v delay", push.uid, push.at.Sub(start), delay)
+		}
+		got[push.uid] = push.flush
+	}
+	if flush, ok := got["u1"]; !ok || !flush {
+		t.Fatalf("u1 flush = %v, %v, want merged flush", flush, ok)
+	}
+	if flush, ok := got["u2"]; !ok || flush {
+		t.Fatalf("u2 flush = %v, %v, want no flush", flush, ok)
+	}
+	assertNoPush(t, pushes, 2*delay)
+	if n := loadPushCoalesced.Value() - coalesced; n != 2 {
+		t.Fatalf("coalesced = %v, want 2", n)
+	}
+
+	// 推送完成后的新触发重新计时
+	p.schedule("u1", false)
+	if push := nextPush(t, pushes); push.uid != "u1" || push.flush {
+		t.Fatalf("push after reschedule = %+v", push)
+	}
+}
+
+func TestLoadPusherDefersWhenQueueFull(t *testing.T) {
+	const delay = 10 * time.Millisecond
+	release := make(chan struct{})
+	pushes, push := recordPushes(release)
+	p := newLoadPusher(delay, 1, 1, push)
+	defer p.stop(context.Background())
+
+	deferred := loadPushDeferred.Value()
+	// u1 占住唯一的 worker, u2 占满队列, u3 到期时只能延后
+	p.schedule("u1", false)
+	time.Sleep(3 * delay)
+	p.schedule("u2", false)
+	time.Sleep(3 * delay)
+	p.schedule("u3", false)
+	time.Sleep(3 * delay)
+	if loadPushDeferred.Value() == deferred {
+		t.Fatal("due push was not deferred while the queue was full")
+	}
+	// 延后期间的触发继续合并
+	p.schedule("u3", true)
+
+	var order []loadPush
+	for i := 0; i < 3; i++ {
+		release <- struct{}{}
+		order = append(order, nextPush(t, pushes))
+	}
+	if order[0].uid != "u1" || order[1].uid != "u2" || order[2].uid != "u3" || !order[2].flush {
+		t.Fatalf("pushes = %+v, want u1, u2, then u3 with flush", order)
+	}
+	assertNoPush(t, pushes, 3*delay)
+}
+
+func TestLoadPusherStop(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	release := make(chan struct{})
+	pushes, push := recordPushes(release)
+	p := newLoadPusher(delay, 1, 4, push)
+
+	p.schedule("u1", false)
+	time.Sleep(3 * delay)
+	// u1 正在执行, u2 尚未到期
+	p.schedule("u2", false)
+	dropped := loadPushDropped.Value()
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- p.stop(context.Background()) }()
+	select {
+	case err := <-stopped:
+		t.Fatalf("stop returned %v before the running push finished", err)
+	case <-time.After(delay):
+	}
+	release <- struct{}{}
+	if push := nextPush(t, pushes); push.uid != "u1" {
+		t.Fatalf("push = %+v, want u1", push)
+	}
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+
+	p.schedule("u3", false)
+	time.Sleep(3 * delay)
+	if n := loadPushDropped.Value() - dropped; n != 2 {
+		t.Fatalf("dropped = %v, want u2 and u3", n)
+	}
+	if err := p.stop(context.Background()); err != nil {
+		t.Fatalf("second stop err: %v", err)
+	}
+}
+
+func TestLoadPusherStopTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	_, push := recordPushes(release)
+	p := newLoadPusher(time.Millisecond, 1, 1, push)
+	p.schedule("u1", false)
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.stop(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("stop err = %v, want deadline exceeded", err)
+	}
+}
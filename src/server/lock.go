@@ -0,0 +1,21 @@
+package server
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// stripedLock 按 key 哈希分段的互斥锁, 用于串行化同一房间的操作
+type stripedLock struct {
+	locks []sync.Mutex
+}
+
+func newStripedLock(n int) *stripedLock {
+	return &stripedLock{locks: make([]sync.Mutex, n)}
+}
+
+func (l *stripedLock) get(key string) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return &l.locks[h.Sum32()%uint32(len(l.locks))]
+}
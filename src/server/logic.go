@@ -6,11 +6,15 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"framework/api"
 	"framework/api/model"
+	"framework/db"
 	"framework/logger"
 	"framework/tool"
+	"logic/conf"
+	"logic/dao"
 	"sync"
 	"time"
 )
@@ -19,8 +23,16 @@ func (s *Server) GetLoadData(uid string) (interface{}, error) {
 	var wg sync.WaitGroup
 	var lock sync.RWMutex
 	user, friends, groups := &model.User{}, []*model.FriendData{}, []*model.GroupData{}
+	friendRequests := []*dao.FriendRequest{}
 	errs := make([]error, 0)
 
+	// 先取版本号, 之后发生的变更会在下次同步时重放
+	version, err := dao.GetSyncVersion(uid)
+	if err != nil {
+		logger.Error("Logic.LoadData get sync version err: %v", err)
+		return nil, err
+	}
+
 	wg.Add(1)
 	go func(uid string) {
 		defer wg.Done()
@@ -39,6 +51,9 @@ func (s *Server) GetLoadData(uid string) (interface{}, error) {
 		// friends
 		defer wg.Done()
 		fs, err := model.GetFriendDatasByUID(uid)
+		if err == nil {
+			fs, err = s.filterBlockedFriends(uid, fs)
+		}
 		if err != nil {
 			lock.Lock()
 			errs = append(errs, err)
@@ -61,24 +76,57 @@ func (s *Server) GetLoadData(uid string) (interface{}, error) {
 		}
 		groups = gs
 	}(uid)
+
+	wg.Add(1)
+	go func(uid string) {
+		// pending friend requests
+		defer wg.Done()
+		rs, err := dao.GetPendingFriendRequestsByUID(uid)
+		if err != nil {
+			lock.Lock()
+			errs = append(errs, err)
+			lock.Unlock()
+			return
+		}
+		friendRequests = rs
+	}(uid)
 	wg.Wait()
 
 	if len(errs) > 0 {
 		logger.Error("Logic.LoadData err: %v", errs[0])
 		return nil, errs[0]
 	}
+	friendDatas, groupDatas, err := s.wrapLoadData(uid, friends, groups)
+	if err != nil {
+		logger.Error("Logic.LoadData count unread err: %v", err)
+		return nil, err
+	}
 	return struct {
-		User    *model.User         `json:"user"`
-		Friends []*model.FriendData `json:"friends"`
-		Groups  []*model.GroupData  `json:"groups"`
+		User           *model.User          `json:"user"`
+		Friends        []*FriendData        `json:"friends"`
+		Groups         []*GroupData         `json:"groups"`
+		FriendRequests []*dao.FriendRequest `json:"friendRequests"`
+		Version        int64                `json:"version"`
 	}{
 		user,
-		friends,
-		groups,
+		friendDatas,
+		groupDatas,
+		friendRequests,
+		version,
 	}, nil
 }
 
+// PushLoadData 推送全量 load 数据, 短时间内对同一用户的多次调用会合并为一次
 func (s *Server) PushLoadData(uid string) {
+	s.loadPusher.schedule(uid, false)
+}
+
+// PushConnectData 新连接建立后推送 load 数据, 之后补发收件箱中未确认的消息
+func (s *Server) PushConnectData(uid string) {
+	s.loadPusher.schedule(uid, true)
+}
+
+func (s *Server) pushLoadDataNow(uid string, flush bool) {
 	start := time.Now()
 	loadData, err := s.GetLoadData(uid)
 	logger.Info("Logic.PushLoadData /load %v", time.Since(start))
@@ -86,15 +134,27 @@ func (s *Server) PushLoadData(uid string) {
 		logger.Error("Logic.PushLoadData Error: %v", err)
 		return
 	}
-	go s.InvokeTarget(api.EventLoad, loadData, uid)
+	s.InvokeTarget(api.EventLoad, loadData, uid)
+	if flush {
+		s.FlushInbox(uid)
+	}
+}
+
+func (s *Server) PushChatMessage(message *dao.ChatMessage) {
+	targets, err := s.ChatTargets(message.From, message.To)
+	if err != nil {
+		return
+	}
+	s.deliverChatMessage(message, targets)
+	s.pushMentions(message, targets)
 }
 
-func (s *Server) PushChatMessage(message *model.ChatMessage) {
-	roomID := message.To
+// ChatTargets 返回房间内应收到 from 所发消息的用户
+func (s *Server) ChatTargets(from, roomID string) ([]string, error) {
 	room, err := model.GetRoomByID(roomID)
 	if err != nil {
 		logger.Error("Logic.PushChat no such room: %v", roomID)
-		return
+		return nil, err
 	}
 	targets := []string{}
 	if room.OneToOne {
@@ -102,76 +162,228 @@ func (s *Server) PushChatMessage(message *model.ChatMessage) {
 		//single
 		targets, err = model.GetFriendsByRoomID(room.RoomID)
 		if err != nil {
-			return
+			return nil, err
 		}
+		targets = s.filterChatTargets(from, targets)
 
 	} else {
 		//group
-		targets, err = model.GetUserIDsByGroupID(message.To)
+		targets, err = model.GetUserIDsByGroupID(roomID)
 		if err != nil {
 			logger.Error("Logic.PushChat Get Group Users err: %v", err)
-			return
+			return nil, err
+		}
+	}
+	return targets, nil
+}
+
+// SendChatMessage 分配消息 ID 与房间序号, 再按投递模式持久化并推送
+// ordered 模式下先写库再推送; 推送可能乱序到达, 客户端按 seq 排序
+func (s *Server) SendChatMessage(message *dao.ChatMessage) error {
+	if err := s.checkChatAllowed(message.From, message.To); err != nil {
+		return err
+	}
+	if err := s.assignSeq(message); err != nil {
+		return err
+	}
+
+	// ordered 模式在请求内直接写库, 不经过消息队列; Shutdown 等待处理中的请求即可保证已返回的消息都已写入
+	if s.logicCfg.Chat.DeliveryMode == conf.DeliveryModeOrdered {
+		if err := dao.InsertChatMessage(message); err != nil {
+			logger.Error("Logic.SendChatMessage persist message [%v] err: %v", message.MessageID, err)
+			return err
 		}
+		s.spawn(func() { s.advanceSenderCursors([]*dao.ChatMessage{message}) })
+		s.PushChatMessage(message)
+		return nil
+	}
+	// 同步入队, 保证 Shutdown 关闭队列前消息已经写入
+	if err := s.Produce(message); err != nil {
+		return err
 	}
-	s.InvokeTarget(api.EventChat, message, targets...)
+	s.spawn(func() { s.PushChatMessage(message) })
+	return nil
 }
 
-func (s *Server) ConsumeMessage(message *model.ChatMessage) {
-	err := model.InsertChatMessage(message)
+// assignSeq 只在分配序号与消息 ID 时持有房间锁, 保证同一房间内两者顺序一致
+func (s *Server) assignSeq(message *dao.ChatMessage) error {
+	lock := s.roomLocks.get(message.To)
+	lock.Lock()
+	defer lock.Unlock()
+
+	seq, err := dao.NextRoomSeq(message.To)
+	if err != nil {
+		logger.Error("Logic.SendChatMessage alloc seq for room %v err: %v", message.To, err)
+		return err
+	}
+	message.MessageID = dao.NewMessageID()
+	message.Seq = seq
+	return nil
+}
+
+// SendChatMessageOnce 按 (sender, clientMsgID) 在去重窗口内只发送一次
+// 命中重复时不再持久化和推送, 返回首次发送的消息; 去重窗口不大于 0 时不去重
+func (s *Server) SendChatMessageOnce(message *dao.ChatMessage) (*dao.ChatMessage, error) {
+	window := time.Duration(s.logicCfg.Chat.DedupeWindow) * time.Second
+	if len(message.ClientMsgID) == 0 || window <= 0 {
+		return nil, s.SendChatMessage(message)
+	}
+	original, claimed, err := dao.ClaimChatClientID(message.From, message.ClientMsgID, window)
+	if errors.Is(err, dao.ErrChatInProgress) {
+		return nil, ErrChatInProgress
+	}
+	if err != nil {
+		logger.Error("Logic.SendChatMessageOnce claim client id [%v:%v] err: %v", message.From, message.ClientMsgID, err)
+		return nil, err
+	}
+	if !claimed {
+		logger.Info("Logic.SendChatMessageOnce duplicate client id [%v:%v] -> %v", message.From, message.ClientMsgID, original.MessageID)
+		return original, nil
+	}
+	if err = s.SendChatMessage(message); err != nil {
+		s.releaseChatClientID(message)
+		return nil, err
+	}
+	if err = dao.SaveChatClientID(message, window); err != nil {
+		// 消息已发送, 释放占用总比让重试在整个窗口内都收到处理中要好
+		logger.Error("Logic.SendChatMessageOnce save client id err: %v", err)
+		s.releaseChatClientID(message)
+	}
+	return nil, nil
+}
+
+func (s *Server) releaseChatClientID(message *dao.ChatMessage) {
+	if err := dao.ReleaseChatClientID(message.From, message.ClientMsgID); err != nil {
+		logger.Error("Logic.SendChatMessageOnce release client id [%v:%v] err: %v", message.From, message.ClientMsgID, err)
+	}
+}
+
+func (s *Server) ConsumeMessage(message *dao.ChatMessage) error {
+	err := dao.InsertChatMessage(message)
 	if err != nil {
 		logger.Error("Logic.ConsumeEvent err: %v", err)
 	}
+	return err
+}
+
+// ConsumeMessages 批量写入, 整批失败时退化为逐条写入, 单条失败会单独重试一次
+// messageID 上有唯一索引, 已经写入的消息在重试时按重复键视为成功, 不会重复落库
+// 写入成功的消息随后批量推进发送者的已读序号
+func (s *Server) ConsumeMessages(messages []*dao.ChatMessage) []error {
+	errs, err := dao.InsertChatMessages(messages)
+	if err != nil {
+		logger.Error("Logic.ConsumeMessages bulk insert %v messages err: %v, fallback to single insert", len(messages), err)
+		errs = make([]error, len(messages))
+		for i, message := range messages {
+			errs[i] = s.ConsumeMessage(message)
+		}
+	} else {
+		for i, message := range messages {
+			if errs[i] == nil {
+				continue
+			}
+			logger.Error("Logic.ConsumeMessages insert message [%+v] err: %v", *message, errs[i])
+			errs[i] = s.ConsumeMessage(message)
+		}
+	}
+	stored := make([]*dao.ChatMessage, 0, len(messages))
+	for i, message := range messages {
+		if errs[i] == nil {
+			stored = append(stored, message)
+		}
+	}
+	s.advanceSenderCursors(stored)
+	return errs
 }
 
 func (s *Server) InvokeTarget(event string, data interface{}, targets ...string) {
-	// TODO find target on different gate nodes.
+	s.InvokeTargetWithCallback(event, data, nil, targets...)
+}
+
+// InvokeTargetWithCallback 异步调用 broker, callback 非空时在调用结束后收到结果
+func (s *Server) InvokeTargetWithCallback(event string, data interface{}, callback func(err error), targets ...string) {
 	logger.Info("Logic.InvokeTarget: event:%v, target: %v", event, targets)
-	iR := &api.InvokeRequest{
-		Event:   event,
-		Targets: targets,
-		Data:    data,
-	}
-	//go s.logicBroker.Invoke(iR)
-	go func(data interface{}) {
-		_, err := s.logicBroker.Invoke(iR)
-		if err != nil {
-			logger.Error("Logic.InvokeTarget Error: err: %v event:%v, target: %v, data:%v", err, event, targets, data)
-			return
+	if !s.invokes.add() {
+		logger.Error("Logic.InvokeTarget dropped after shutdown: event:%v, target: %v", event, targets)
+		if callback != nil {
+			callback(ErrServerShuttingDown)
 		}
-	}(iR)
+		return
+	}
+	go func() {
+		defer s.invokes.done()
+		err := s.invokeGates(event, data, targets)
+		if callback != nil {
+			callback(err)
+		}
+	}()
 }
 
-func (s *Server) InviteFriendsToGroup(friends []string, groupID string) error {
+// InviteFriendsToGroup 有邀请权限的成员邀请自己的好友入群
+func (s *Server) InviteFriendsToGroup(operator string, friends []string, groupID string) error {
+	if _, err := s.checkGroupPermission(groupID, operator, permInvite); err != nil {
+		return err
+	}
 	for _, friend := range friends {
-		err := model.CreateGroupUser(groupID, friend)
+		if _, err := model.GetFriend(operator, friend); err != nil {
+			if db.IsNotExistError(err) {
+				return ErrNotFriend
+			}
+			return err
+		}
+		banned, err := dao.IsGroupBanned(groupID, friend)
 		if err != nil {
 			return err
 		}
+		if banned {
+			return ErrBannedFromGroup
+		}
+	}
+	members, err := model.GetUserIDsByGroupID(groupID)
+	if err != nil {
+		return err
+	}
+	added := make([]string, 0, len(friends))
+	defer func() {
+		// 中途失败时已加入的好友同样收到群组数据, 原成员收到新成员
+		if len(added) > 0 {
+			s.spawn(func() { s.PushMembersJoined(groupID, added...) })
+		}
+	}()
+	for _, friend := range friends {
+		// 已在群内的好友不重复加入
+		if containsString(members, friend) || containsString(added, friend) {
+			continue
+		}
+		if err = model.CreateGroupUser(groupID, friend); err != nil {
+			return err
+		}
+		added = append(added, friend)
 	}
-	// TODO 服务端主动推送客户端刷新
 	return nil
 }
 
-func (s *Server) PullMessageByPage(uid, friendID, groupID string, current, pageSize int64) ([]*model.ChatMessage, error) {
+func (s *Server) PullMessageByPage(uid, friendID, groupID string, current, pageSize int64) ([]*dao.ChatMessage, error) {
+	// 从 logic 自己的消息集合读取, 返回撤回与编辑后的内容并隐藏自己删除的消息
+	roomID, err := s.pullRoomID(uid, friendID, groupID)
+	if err != nil {
+		return nil, err
+	}
+	return dao.GetRoomMessagesByPage(roomID, uid, current, pageSize)
+}
+
+// pullRoomID 好友优先, 返回双方的房间或群组 ID
+func (s *Server) pullRoomID(uid, friendID, groupID string) (string, error) {
 	if len(friendID) > 0 {
 		friend, err := model.GetFriend(uid, friendID)
 		if err != nil {
-			return nil, err
+			return "", err
 		}
-		messages, err := model.GetFriendMessageWithPage(friend, current, pageSize)
-		if err != nil {
-			return nil, err
-		}
-		return messages, nil
+		return friend.RoomID, nil
 	} else if len(groupID) > 0 {
-		group := &model.Group{GroupID: groupID}
-		messages, err := model.GetGroupMessageWithPage(group, current, pageSize)
-		if err != nil {
-			return nil, err
-		}
-		return messages, nil
+		return groupID, nil
 	}
-	return nil, api.ErrorCodeToError(api.ErrorHttpParamInvalid)
+	return "", api.ErrorCodeToError(api.ErrorHttpParamInvalid)
 }
 
 func (s *Server) UpdateUserInfo(uid string, account string, password string, avatar string) (*model.User, error) {
@@ -197,7 +409,11 @@ func (s *Server) UpdateUserInfo(uid string, account string, password string, ava
 	return user, nil
 }
 
-func (s *Server) UpdateGroupInfo(id string, groupName string, groupNotice string) (*model.Group, error) {
+// UpdateGroupInfo 有修改权限的成员更新群名称与公告
+func (s *Server) UpdateGroupInfo(operator, id string, groupName string, groupNotice string) (*model.Group, error) {
+	if _, err := s.checkGroupPermission(id, operator, permUpdateGroup); err != nil {
+		return nil, err
+	}
 	group, err := model.GetGroupByGroupID(id)
 	if nil != err {
 		return nil, err
@@ -244,6 +460,10 @@ func (s *Server) CreateAndGetGroupData(groupName, groupAdmin string) (*model.Gro
 		logger.Error(api.MongoDBError, err)
 		return nil, err
 	}
+	if err = dao.SetGroupRole(group.GroupID, groupAdmin, RoleOwner); err != nil {
+		logger.Error(api.MongoDBError, err)
+		return nil, err
+	}
 	// 返回数据
 	groupData, err := model.GetGroupDataByGroupID(group.GroupID)
 	if err != nil {
@@ -254,7 +474,15 @@ func (s *Server) CreateAndGetGroupData(groupName, groupAdmin string) (*model.Gro
 }
 
 func (s *Server) JoinAndGetGroupData(uid, groupID string) (*model.GroupData, error) {
-	err := model.CreateGroupUser(groupID, uid)
+	banned, err := dao.IsGroupBanned(groupID, uid)
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+		return nil, err
+	}
+	if banned {
+		return nil, ErrBannedFromGroup
+	}
+	err = model.CreateGroupUser(groupID, uid)
 	if err != nil {
 		logger.Error(api.MongoDBError, err)
 		return nil, err
@@ -268,6 +496,21 @@ func (s *Server) JoinAndGetGroupData(uid, groupID string) (*model.GroupData, err
 }
 
 func (s *Server) LeaveAndGetGroupUser(uid, groupID string) (*model.GroupUser, error) {
+	role, err := s.GetGroupRole(groupID, uid)
+	if err != nil {
+		return nil, err
+	}
+	if role == RoleOwner {
+		// 群主只有在最后一人时才能直接退出
+		uids, err := model.GetUserIDsByGroupID(groupID)
+		if err != nil {
+			logger.Error(api.MongoDBError, err)
+			return nil, err
+		}
+		if len(uids) > 1 {
+			return nil, ErrOwnerCannotLeave
+		}
+	}
 	gUser, err := model.DeleteGroupUser(groupID, uid)
 	if err != nil {
 		logger.Error(api.MongoDBError, err)
@@ -275,3 +518,72 @@ func (s *Server) LeaveAndGetGroupUser(uid, groupID string) (*model.GroupUser, er
 	}
 	return gUser, nil
 }
+
+// KickAndGetGroupUser 管理员/群主移除角色更低的成员
+func (s *Server) KickAndGetGroupUser(operator, groupID, target string) (*model.GroupUser, error) {
+	if _, err := s.checkManageMember(groupID, operator, target, permKick); err != nil {
+		return nil, err
+	}
+	gUser, err := model.DeleteGroupUser(groupID, target)
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+		return nil, err
+	}
+	return gUser, nil
+}
+
+// BanGroupUser 禁止用户加入群组, 用户在群内时同时移出; 返回被移出的成员, 不在群内时为 nil
+func (s *Server) BanGroupUser(operator, groupID, target string) (*model.GroupUser, error) {
+	member, err := s.isGroupMember(target, groupID)
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+		return nil, err
+	}
+	if member {
+		_, err = s.checkManageMember(groupID, operator, target, permKick)
+	} else {
+		_, err = s.checkGroupPermission(groupID, operator, permKick)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = dao.AddGroupBan(&dao.GroupBan{GroupID: groupID, UID: target, Operator: operator, Time: time.Now()})
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+		return nil, err
+	}
+	if !member {
+		return nil, nil
+	}
+	gUser, err := model.DeleteGroupUser(groupID, target)
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+		return nil, err
+	}
+	return gUser, nil
+}
+
+// UnbanGroupUser 解除禁止, 用户需重新加入或被邀请
+func (s *Server) UnbanGroupUser(operator, groupID, target string) error {
+	if _, err := s.checkGroupPermission(groupID, operator, permKick); err != nil {
+		return err
+	}
+	err := dao.DeleteGroupBan(groupID, target)
+	if err != nil {
+		logger.Error(api.MongoDBError, err)
+	}
+	return err
+}
+
+// PushGroupMemberRemoved 通知剩余成员与被移出的用户, 并记录双方的增量变更
+func (s *Server) PushGroupMemberRemoved(removed *GroupMemberRemoved) {
+	uids, err := model.GetUserIDsByGroupID(removed.GroupID)
+	if err != nil {
+		logger.Error("Logic.PushGroupMemberRemoved get group users err: %v", err)
+		return
+	}
+	s.InvokeTarget(EventGroupMemberRemoved, removed, uids...)
+	s.InvokeTarget(EventRemovedFromGroup, removed, removed.UID)
+	s.PushChange(ChangeMemberLeft, &MemberLeft{GroupID: removed.GroupID, UID: removed.UID}, uids...)
+	s.PushChange(ChangeGroupRemoved, &GroupRemoved{GroupID: removed.GroupID}, removed.UID)
+}
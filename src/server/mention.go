@@ -0,0 +1,95 @@
+package server
+
+import (
+	"framework/api/model"
+	"framework/logger"
+	"logic/dao"
+)
+
+var (
+	ErrMentionNotGroup  = NewCodeError(ErrorCodeForbidden, "mentions are only allowed in group chats")
+	ErrMentionNotMember = NewCodeError(ErrorCodeForbidden, "mentioned user is not a member of the group")
+)
+
+// Mention 推送给被提及用户的通知
+type Mention struct {
+	MessageID string `json:"messageID"`
+	RoomID    string `json:"roomID"`
+	From      string `json:"from"`
+	Seq       int64  `json:"seq"`
+	All       bool   `json:"all"`
+}
+
+// AttachMentions 校验被提及的用户都是群成员, 去重并去掉发送者自己
+func (s *Server) AttachMentions(message *dao.ChatMessage, mentions []string, all bool) error {
+	if len(mentions) == 0 && !all {
+		return nil
+	}
+	room, err := model.GetRoomByID(message.To)
+	if err != nil {
+		return err
+	}
+	if room.OneToOne {
+		return ErrMentionNotGroup
+	}
+	members, err := model.GetUserIDsByGroupID(message.To)
+	if err != nil {
+		logger.Error("Logic.AttachMentions get group users err: %v", err)
+		return err
+	}
+	uids, err := mentionTargets(message.From, mentions, members)
+	if err != nil {
+		return err
+	}
+	message.Mentions = uids
+	message.MentionAll = all
+	return nil
+}
+
+// mentionTargets 按首次出现的顺序去重并去掉发送者, 提及非成员时返回 ErrMentionNotMember
+func mentionTargets(from string, mentions, members []string) ([]string, error) {
+	seen := make(map[string]bool, len(mentions))
+	uids := make([]string, 0, len(mentions))
+	for _, uid := range mentions {
+		if uid == from || seen[uid] {
+			continue
+		}
+		if !containsString(members, uid) {
+			return nil, ErrMentionNotMember
+		}
+		seen[uid] = true
+		uids = append(uids, uid)
+	}
+	return uids, nil
+}
+
+// pushMentions 在普通消息推送之外单独通知被提及的成员, targets 为消息的接收者
+func (s *Server) pushMentions(message *dao.ChatMessage, targets []string) {
+	if len(message.Mentions) == 0 && !message.MentionAll {
+		return
+	}
+	err := dao.AddMentions(message.To, message.Seq, message.Mentions, message.MentionAll, s.logicCfg.Chat.MentionLimit)
+	if err != nil {
+		logger.Error("Logic.pushMentions record err: %v", err)
+	}
+	mentioned := message.Mentions
+	if message.MentionAll {
+		mentioned = make([]string, 0, len(targets))
+		for _, target := range targets {
+			if target != message.From {
+				mentioned = append(mentioned, target)
+			}
+		}
+	}
+	if len(mentioned) == 0 {
+		return
+	}
+	mention := &Mention{
+		MessageID: message.MessageID,
+		RoomID:    message.To,
+		From:      message.From,
+		Seq:       message.Seq,
+		All:       message.MentionAll,
+	}
+	s.InvokeTarget(EventMention, mention, mentioned...)
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMentionTargets(t *testing.T) {
+	members := []string{"a", "b", "c"}
+	cases := []struct {
+		name     string
+		mentions []string
+		want     []string
+		err      error
+	}{
+		{"empty", nil, []string{}, nil},
+		{"keeps order", []string{"c", "b"}, []string{"c", "b"}, nil},
+		{"dedupes", []string{"b", "c", "b", "c"}, []string{"b", "c"}, nil},
+		{"drops sender", []string{"a", "b", "a"}, []string{"b"}, nil},
+		{"only sender", []string{"a"}, []string{}, nil},
+		{"not a member", []string{"b", "x"}, nil, ErrMentionNotMember},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := mentionTargets("a", c.mentions, members)
+			if err != c.err {
+				t.Fatalf("mentionTargets err = %v, want %v", err, c.err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("mentionTargets = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"framework/api"
+	"framework/logger"
+	"logic/dao"
+	"time"
+)
+
+var (
+	ErrMessageNotFound  = NewCodeError(ErrorCodeNotFound, "message not found")
+	ErrNotMessageSender = NewCodeError(ErrorCodeForbidden, "only the sender can change the message")
+	ErrRecallExpired    = NewCodeError(ErrorCodeForbidden, "message can no longer be recalled")
+	ErrMessageRecalled  = NewCodeError(ErrorCodeForbidden, "message has been recalled")
+	ErrEditConflict     = NewCodeError(ErrorCodeConflict, "message was changed by another edit, reload and retry")
+)
+
+// MessageRecalled 推送给房间成员的撤回通知
+type MessageRecalled struct {
+	MessageID string `json:"messageID"`
+	RoomID    string `json:"roomID"`
+	UID       string `json:"uid"`
+}
+
+// MessageEdited 推送给房间成员的编辑通知
+type MessageEdited struct {
+	MessageID string `json:"messageID"`
+	RoomID    string `json:"roomID"`
+	UID       string `json:"uid"`
+	Content   string `json:"content"`
+	EditTime  int64  `json:"editTime"`
+}
+
+// roomMessage 返回 uid 所在房间中的消息, 消息异步写库时刚发送的消息可能还查不到
+func (s *Server) roomMessage(uid, messageID string) (*dao.ChatMessage, error) {
+	message, err := dao.GetChatMessage(messageID)
+	if err != nil {
+		logger.Error("Logic.roomMessage err: %v", err)
+		return nil, err
+	}
+	if message == nil {
+		return nil, ErrMessageNotFound
+	}
+	ok, err := s.isRoomMember(uid, message.To)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotRoomMember
+	}
+	return message, nil
+}
+
+// senderMessage 只有发送者可以修改未撤回的消息
+func (s *Server) senderMessage(uid, messageID string) (*dao.ChatMessage, error) {
+	message, err := s.roomMessage(uid, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.From != uid {
+		return nil, ErrNotMessageSender
+	}
+	if message.Recalled {
+		return nil, ErrMessageRecalled
+	}
+	return message, nil
+}
+
+// RecallMessage 发送者在撤回时限内撤回消息, 同时从接收者的离线收件箱中移除
+func (s *Server) RecallMessage(uid, messageID string) (*MessageRecalled, error) {
+	message, err := s.senderMessage(uid, messageID)
+	if err != nil {
+		return nil, err
+	}
+	sent, err := dao.MessageTime(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(sent) > time.Duration(s.logicCfg.Chat.RecallWindow)*time.Second {
+		return nil, ErrRecallExpired
+	}
+	ok, err := dao.RecallChatMessage(messageID)
+	if err != nil {
+		logger.Error("Logic.RecallMessage err: %v", err)
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrMessageRecalled
+	}
+	recalled := &MessageRecalled{MessageID: messageID, RoomID: message.To, UID: uid}
+	targets, err := s.ChatTargets(uid, message.To)
+	if err != nil {
+		return recalled, nil
+	}
+	for _, target := range targets {
+		if _, err := dao.AckInbox(target, messageID); err != nil {
+			logger.Error("Logic.RecallMessage remove from inbox err: %v", err)
+		}
+	}
+	s.InvokeTarget(EventMessageRecalled, recalled, targets...)
+	return recalled, nil
+}
+
+// EditMessage 发送者编辑消息, 旧内容写入编辑历史
+func (s *Server) EditMessage(uid, messageID, content string) (*MessageEdited, error) {
+	if len(content) == 0 {
+		return nil, api.ErrorCodeToError(api.ErrorHttpParamInvalid)
+	}
+	message, err := s.senderMessage(uid, messageID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	ok, err := dao.EditChatMessage(message, content, now)
+	if err != nil {
+		logger.Error("Logic.EditMessage err: %v", err)
+		return nil, err
+	}
+	if !ok {
+		// 并发的撤回或编辑先完成, 区分两者, 被其他编辑抢先时客户端可以重新加载后再试
+		return nil, s.editRaceError(messageID)
+	}
+	edited := &MessageEdited{
+		MessageID: messageID,
+		RoomID:    message.To,
+		UID:       uid,
+		Content:   content,
+		EditTime:  now.UnixNano() / int64(time.Millisecond),
+	}
+	targets, err := s.ChatTargets(uid, message.To)
+	if err != nil {
+		return edited, nil
+	}
+	s.InvokeTarget(EventMessageEdited, edited, targets...)
+	return edited, nil
+}
+
+// editRaceError 编辑未命中时重新读取消息, 已撤回返回 ErrMessageRecalled, 否则为编辑冲突
+func (s *Server) editRaceError(messageID string) error {
+	message, err := dao.GetChatMessage(messageID)
+	if err != nil {
+		logger.Error("Logic.EditMessage reload err: %v", err)
+		return err
+	}
+	if message != nil && message.Recalled {
+		return ErrMessageRecalled
+	}
+	return ErrEditConflict
+}
+
+// GetMessageEdits 房间成员查看消息的编辑历史
+func (s *Server) GetMessageEdits(uid, messageID string) ([]*dao.ChatMessageEdit, error) {
+	if _, err := s.roomMessage(uid, messageID); err != nil {
+		return nil, err
+	}
+	return dao.GetChatMessageEdits(messageID)
+}
+
+// DeleteMessageForMe 只对自己隐藏, 其他成员不受影响
+func (s *Server) DeleteMessageForMe(uid, messageID string) error {
+	if _, err := s.roomMessage(uid, messageID); err != nil {
+		return err
+	}
+	if err := dao.DeleteChatMessageFor(messageID, uid); err != nil {
+		logger.Error("Logic.DeleteMessageForMe err: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package server
+
+import (
+	"framework/api"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// accept 关闭期间拒绝新请求, 并记录处理中的请求以便 Shutdown 等待
+func (s *Server) accept(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !s.requests.add() {
+			c.AbortWithStatusJSON(http.StatusOK, api.NewHttpInnerErrorResponse(ErrServerShuttingDown))
+			return
+		}
+		defer s.requests.done()
+		handler(c)
+	}
+}
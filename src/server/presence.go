@@ -0,0 +1,126 @@
+package server
+
+import (
+	"framework/api/model"
+	"framework/logger"
+	"logic/dao"
+	"time"
+)
+
+// PresenceChanged 好友在线状态变化
+type PresenceChanged struct {
+	UID      string `json:"uid"`
+	Online   bool   `json:"online"`
+	LastSeen int64  `json:"lastSeen"`
+}
+
+// ConnectUser 记录新连接, 用户从离线变为在线时通知好友
+func (s *Server) ConnectUser(uid, gate, connID string) {
+	before, total, err := s.registerGate(uid, gate, connID)
+	if err != nil {
+		logger.Error("Logic.ConnectUser register gate err: %v", err)
+		return
+	}
+	if before == 0 && total > 0 {
+		s.spawn(func() { s.pushPresence(uid, &PresenceChanged{UID: uid, Online: true}) })
+	}
+}
+
+// DisconnectUser 最后一个连接断开时记录最后在线时间并通知好友
+func (s *Server) DisconnectUser(uid, gate, connID string) error {
+	total, err := s.unregisterGate(uid, gate, connID)
+	if err != nil {
+		logger.Error("Logic.DisconnectUser unregister gate err: %v", err)
+		return err
+	}
+	if total > 0 {
+		return nil
+	}
+	s.markOffline(uid)
+	return nil
+}
+
+// markOffline 记录最后在线时间并通知好友
+func (s *Server) markOffline(uid string) {
+	now := time.Now()
+	if err := dao.SetLastSeen(uid, now); err != nil {
+		logger.Error("Logic.markOffline set last seen err: %v", err)
+	}
+	s.spawn(func() {
+		s.pushPresence(uid, &PresenceChanged{UID: uid, LastSeen: now.UnixNano() / int64(time.Millisecond)})
+	})
+}
+
+// SetPresenceHidden 在线时切换隐藏状态, 好友看到的效果等同于下线或上线
+func (s *Server) SetPresenceHidden(uid string, hidden bool) error {
+	presences, err := dao.GetPresences(uid)
+	if err != nil {
+		return err
+	}
+	current := presences[uid]
+	if current.Hidden == hidden {
+		return nil
+	}
+	if err = dao.SetPresenceHidden(uid, hidden); err != nil {
+		logger.Error("Logic.SetPresenceHidden err: %v", err)
+		return err
+	}
+	if current.Online {
+		s.notifyFriends(uid, &PresenceChanged{UID: uid, Online: !hidden})
+	}
+	return nil
+}
+
+// pushPresence 隐藏在线状态的用户不通知
+func (s *Server) pushPresence(uid string, change *PresenceChanged) {
+	hidden, err := dao.IsPresenceHidden(uid)
+	if err != nil {
+		logger.Error("Logic.pushPresence err: %v", err)
+		return
+	}
+	if hidden {
+		return
+	}
+	s.notifyFriends(uid, change)
+}
+
+func (s *Server) notifyFriends(uid string, change *PresenceChanged) {
+	friends, err := model.GetFriendDatasByUID(uid)
+	if err == nil {
+		friends, err = s.filterBlockedFriends(uid, friends)
+	}
+	if err != nil {
+		logger.Error("Logic.pushPresence get friends err: %v", err)
+		return
+	}
+	targets := friendUIDs(friends)
+	if len(targets) == 0 {
+		return
+	}
+	s.InvokeTarget(EventPresenceChanged, change, targets...)
+}
+
+// friendPresences 返回好友对外展示的在线状态, 隐藏状态的好友显示为离线
+func (s *Server) friendPresences(friends []*model.FriendData) (map[string]*dao.Presence, error) {
+	presences, err := dao.GetPresences(friendUIDs(friends)...)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range presences {
+		if p.Hidden {
+			p.Online, p.LastSeen = false, 0
+		}
+	}
+	return presences, nil
+}
+
+// friendUIDs 跳过缺少用户信息的好友数据
+func friendUIDs(friends []*model.FriendData) []string {
+	uids := make([]string, 0, len(friends))
+	for _, friend := range friends {
+		if friend != nil && friend.User != nil {
+			uids = append(uids, friend.User.UID)
+		}
+	}
+	return uids
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"framework/api/model"
+	"framework/logger"
+	"logic/dao"
+	"time"
+)
+
+// ReadReceipt 单聊已读回执
+type ReadReceipt struct {
+	RoomID string `json:"roomID"`
+	UID    string `json:"uid"`
+	Seq    int64  `json:"seq"`
+}
+
+// MarkRoomRead 将已读位置推进到 seq, seq 为 0 时标记房间内全部已读; 单聊推送已读回执给对方
+func (s *Server) MarkRoomRead(uid, roomID string, seq int64) (*ReadReceipt, error) {
+	latest, err := dao.GetRoomSeq(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if seq <= 0 || seq > latest {
+		seq = latest
+	}
+	seq, err = dao.AdvanceReadCursor(uid, roomID, seq)
+	if err != nil {
+		logger.Error("Logic.MarkRoomRead advance cursor err: %v", err)
+		return nil, err
+	}
+	if err = dao.ClearMentions(uid, roomID, seq); err != nil {
+		logger.Error("Logic.MarkRoomRead clear mentions err: %v", err)
+	}
+	receipt := &ReadReceipt{RoomID: roomID, UID: uid, Seq: seq}
+
+	room, err := model.GetRoomByID(roomID)
+	if err != nil {
+		logger.Error("Logic.MarkRoomRead no such room: %v", roomID)
+		return receipt, nil
+	}
+	if room.OneToOne {
+		uids, err := model.GetFriendsByRoomID(room.RoomID)
+		if err != nil {
+			logger.Error("Logic.MarkRoomRead get friends err: %v", err)
+			return receipt, nil
+		}
+		targets := make([]string, 0, 1)
+		for _, target := range uids {
+			if target != uid {
+				targets = append(targets, target)
+			}
+		}
+		s.InvokeTarget(EventReadReceipt, receipt, targets...)
+	}
+	return receipt, nil
+}
+
+// advanceSenderCursors 自己发送的消息不计入未读, 在消息写库成功后批量推进发送者的已读序号
+func (s *Server) advanceSenderCursors(messages []*dao.ChatMessage) {
+	now := time.Now()
+	latest := make(map[[2]string]*dao.ReadCursor, len(messages))
+	for _, message := range messages {
+		key := [2]string{message.From, message.To}
+		if cursor, ok := latest[key]; ok && cursor.Seq >= message.Seq {
+			continue
+		}
+		latest[key] = &dao.ReadCursor{UID: message.From, RoomID: message.To, Seq: message.Seq, Time: now}
+	}
+	cursors := make([]*dao.ReadCursor, 0, len(latest))
+	for _, cursor := range latest {
+		cursors = append(cursors, cursor)
+	}
+	if err := dao.AdvanceReadCursors(cursors); err != nil {
+		logger.Error("Logic.advanceSenderCursors err: %v", err)
+	}
+}
+
+// unreadCounts 返回各房间的未读数与已读序号
+func (s *Server) unreadCounts(uid string, roomIDs []string) (map[string]int64, map[string]int64, error) {
+	latest, err := dao.GetRoomSeqs(roomIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	read, err := dao.GetReadSeqs(uid, roomIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	counts := make(map[string]int64, len(roomIDs))
+	for _, roomID := range roomIDs {
+		if n := latest[roomID] - read[roomID]; n > 0 {
+			counts[roomID] = n
+		}
+	}
+	return counts, read, nil
+}
+
+// wrapLoadData 为 load 数据中的好友与群组附加未读数, 好友附加在线状态, 群组附加提及数
+func (s *Server) wrapLoadData(uid string, friends []*model.FriendData, groups []*model.GroupData) ([]*FriendData, []*GroupData, error) {
+	roomIDs := make([]string, 0, len(friends)+len(groups))
+	for _, friend := range friends {
+		roomIDs = append(roomIDs, friend.RoomID)
+	}
+	for _, group := range groups {
+		roomIDs = append(roomIDs, group.Group.GroupID)
+	}
+	counts, read, err := s.unreadCounts(uid, roomIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	presences, err := s.friendPresences(friends)
+	if err != nil {
+		return nil, nil, err
+	}
+	fds := make([]*FriendData, 0, len(friends))
+	for _, friend := range friends {
+		fd := &FriendData{FriendData: friend, Unread: counts[friend.RoomID]}
+		if friend.User != nil {
+			if p, ok := presences[friend.User.UID]; ok {
+				fd.Online, fd.LastSeen = p.Online, p.LastSeen
+			}
+		}
+		fds = append(fds, fd)
+	}
+	groupIDs := roomIDs[len(friends):]
+	mentions, err := dao.CountMentions(uid, read, groupIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	gds := make([]*GroupData, 0, len(groups))
+	for _, group := range groups {
+		groupID := group.Group.GroupID
+		gds = append(gds, &GroupData{GroupData: group, Unread: counts[groupID], Mentions: mentions[groupID]})
+	}
+	return fds, gds, nil
+}
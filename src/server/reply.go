@@ -0,0 +1,74 @@
+package server
+
+import (
+	"framework/logger"
+	"logic/dao"
+)
+
+var ErrReplyOtherRoom = NewCodeError(ErrorCodeForbidden, "replied message is not in the same room")
+
+// quoteOf 截取被回复消息的前 QuoteLength 个字符
+func (s *Server) quoteOf(message *dao.ChatMessage) *dao.Quote {
+	content := []rune(message.Content)
+	if limit := s.logicCfg.Chat.QuoteLength; limit > 0 && len(content) > limit {
+		content = content[:limit]
+	}
+	return &dao.Quote{
+		MessageID: message.MessageID,
+		From:      message.From,
+		Type:      message.Type,
+		Content:   string(content),
+		Recalled:  message.Recalled,
+	}
+}
+
+// sameRoomMessage 返回同一房间内的消息
+func (s *Server) sameRoomMessage(roomID, messageID string) (*dao.ChatMessage, error) {
+	message, err := dao.GetChatMessage(messageID)
+	if err != nil {
+		logger.Error("Logic.sameRoomMessage err: %v", err)
+		return nil, err
+	}
+	if message == nil {
+		return nil, ErrMessageNotFound
+	}
+	if message.To != roomID {
+		return nil, ErrReplyOtherRoom
+	}
+	return message, nil
+}
+
+// AttachReply 校验回复与话题属于消息所在房间并附加引用摘要
+// 话题始终指向顶层消息; 只回复话题内的消息时自动加入该话题
+func (s *Server) AttachReply(message *dao.ChatMessage, replyTo, threadRoot string) error {
+	if len(replyTo) > 0 {
+		replied, err := s.sameRoomMessage(message.To, replyTo)
+		if err != nil {
+			return err
+		}
+		message.ReplyTo = replied.MessageID
+		message.Quote = s.quoteOf(replied)
+		if len(threadRoot) == 0 {
+			threadRoot = replied.ThreadRoot
+		}
+	}
+	if len(threadRoot) > 0 {
+		root, err := s.sameRoomMessage(message.To, threadRoot)
+		if err != nil {
+			return err
+		}
+		message.ThreadRoot = root.MessageID
+		if len(root.ThreadRoot) > 0 {
+			message.ThreadRoot = root.ThreadRoot
+		}
+	}
+	return nil
+}
+
+// PullThreadByPage 房间成员分页拉取话题内的回复
+func (s *Server) PullThreadByPage(uid, threadRoot string, current, pageSize int64) ([]*dao.ChatMessage, error) {
+	if _, err := s.roomMessage(uid, threadRoot); err != nil {
+		return nil, err
+	}
+	return dao.GetThreadMessagesByPage(threadRoot, uid, current, pageSize)
+}
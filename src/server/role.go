@@ -0,0 +1,185 @@
+package server
+
+import (
+	"framework/api/model"
+	"framework/logger"
+	"logic/dao"
+)
+
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+type permission int
+
+const (
+	permUpdateGroup permission = iota
+	permInvite
+	permKick
+	permManageAdmin
+	permTransferOwner
+)
+
+// rolePermissions 群角色权限矩阵
+var rolePermissions = map[string]map[permission]bool{
+	RoleOwner: {
+		permUpdateGroup:   true,
+		permInvite:        true,
+		permKick:          true,
+		permManageAdmin:   true,
+		permTransferOwner: true,
+	},
+	RoleAdmin: {
+		permUpdateGroup: true,
+		permInvite:      true,
+		permKick:        true,
+	},
+	RoleMember: {},
+}
+
+var roleRank = map[string]int{
+	RoleOwner:  3,
+	RoleAdmin:  2,
+	RoleMember: 1,
+}
+
+var (
+	ErrPermissionDenied   = NewCodeError(ErrorCodeForbidden, "caller has no permission in the group")
+	ErrTargetNotManagable = NewCodeError(ErrorCodeForbidden, "target has an equal or higher role")
+	ErrOwnerCannotLeave   = NewCodeError(ErrorCodeForbidden, "group owner must transfer ownership before leaving")
+	ErrBannedFromGroup    = NewCodeError(ErrorCodeForbidden, "user is banned from the group")
+)
+
+// GroupRoleChange 群角色变化推送
+type GroupRoleChange struct {
+	GroupID string `json:"groupID"`
+	UID     string `json:"uid"`
+	Role    string `json:"role"`
+}
+
+// GetGroupRole 没有 role 字段的旧数据按 Group.GroupAdmin 判断群主
+func (s *Server) GetGroupRole(groupID, uid string) (string, error) {
+	gUser, err := dao.GetGroupUser(groupID, uid)
+	if err != nil {
+		return "", err
+	}
+	if gUser == nil {
+		return "", ErrNotGroupMember
+	}
+	if len(gUser.Role) > 0 {
+		return gUser.Role, nil
+	}
+	group, err := model.GetGroupByGroupID(groupID)
+	if err != nil {
+		return "", err
+	}
+	if group.GroupAdmin == uid {
+		return RoleOwner, nil
+	}
+	return RoleMember, nil
+}
+
+// checkGroupPermission 返回操作者的角色
+func (s *Server) checkGroupPermission(groupID, uid string, perm permission) (string, error) {
+	role, err := s.GetGroupRole(groupID, uid)
+	if err != nil {
+		return "", err
+	}
+	if !rolePermissions[role][perm] {
+		return "", ErrPermissionDenied
+	}
+	return role, nil
+}
+
+// checkManageMember 操作者需具备 perm 且角色高于被操作成员, 返回被操作成员的角色
+func (s *Server) checkManageMember(groupID, operator, target string, perm permission) (string, error) {
+	role, err := s.checkGroupPermission(groupID, operator, perm)
+	if err != nil {
+		return "", err
+	}
+	targetRole, err := s.GetGroupRole(groupID, target)
+	if err != nil {
+		return "", err
+	}
+	if !outranks(role, targetRole) {
+		return "", ErrTargetNotManagable
+	}
+	return targetRole, nil
+}
+
+// outranks 只能管理角色低于自己的成员, 未知角色不高于任何角色
+func outranks(role, targetRole string) bool {
+	return roleRank[role] > roleRank[targetRole]
+}
+
+func (s *Server) setGroupRole(groupID, uid, role string) error {
+	if err := dao.SetGroupRole(groupID, uid, role); err != nil {
+		logger.Error("Logic.setGroupRole [%v:%v] -> %v err: %v", groupID, uid, role, err)
+		return err
+	}
+	s.pushGroupRoleChange(groupID, uid, role)
+	return nil
+}
+
+func (s *Server) pushGroupRoleChange(groupID, uid, role string) {
+	targets, err := model.GetUserIDsByGroupID(groupID)
+	if err != nil {
+		logger.Error("Logic.pushGroupRoleChange get group users err: %v", err)
+		return
+	}
+	s.InvokeTarget(EventGroupRoleChanged, &GroupRoleChange{GroupID: groupID, UID: uid, Role: role}, targets...)
+}
+
+// PromoteGroupAdmin 群主将成员设为管理员
+func (s *Server) PromoteGroupAdmin(operator, groupID, target string) error {
+	targetRole, err := s.checkManageMember(groupID, operator, target, permManageAdmin)
+	if err != nil {
+		return err
+	}
+	if targetRole == RoleAdmin {
+		return nil
+	}
+	return s.setGroupRole(groupID, target, RoleAdmin)
+}
+
+// DemoteGroupAdmin 群主将管理员降为普通成员
+func (s *Server) DemoteGroupAdmin(operator, groupID, target string) error {
+	targetRole, err := s.checkManageMember(groupID, operator, target, permManageAdmin)
+	if err != nil {
+		return err
+	}
+	if targetRole == RoleMember {
+		return nil
+	}
+	return s.setGroupRole(groupID, target, RoleMember)
+}
+
+// TransferGroupOwner 转让群主, 原群主变为管理员
+// 两个成员的角色在同一事务中修改; 之后同步的 Group.GroupAdmin 只用于没有 role 字段的旧数据, 失败不影响判断群主
+func (s *Server) TransferGroupOwner(operator, groupID, target string) error {
+	if _, err := s.checkManageMember(groupID, operator, target, permTransferOwner); err != nil {
+		return err
+	}
+	transferred, err := dao.TransferGroupOwner(groupID, operator, target, RoleOwner, RoleAdmin)
+	if err != nil {
+		logger.Error("Logic.TransferGroupOwner [%v] %v -> %v err: %v", groupID, operator, target, err)
+		return err
+	}
+	if !transferred {
+		return ErrPermissionDenied
+	}
+	s.pushGroupRoleChange(groupID, target, RoleOwner)
+	s.pushGroupRoleChange(groupID, operator, RoleAdmin)
+
+	group, err := model.GetGroupByGroupID(groupID)
+	if err == nil {
+		group.GroupAdmin = target
+		err = model.UpdateGroup(group)
+	}
+	if err != nil {
+		logger.Error("Logic.TransferGroupOwner sync group admin [%v] err: %v", groupID, err)
+	}
+	return nil
+}
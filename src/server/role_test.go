@@ -0,0 +1,42 @@
+package server
+
+import "testing"
+
+func TestRolePermissions(t *testing.T) {
+	perms := []permission{permUpdateGroup, permInvite, permKick, permManageAdmin, permTransferOwner}
+	want := map[string][]bool{
+		RoleOwner:  {true, true, true, true, true},
+		RoleAdmin:  {true, true, true, false, false},
+		RoleMember: {false, false, false, false, false},
+		"unknown":  {false, false, false, false, false},
+	}
+	for role, allowed := range want {
+		for i, perm := range perms {
+			if got := rolePermissions[role][perm]; got != allowed[i] {
+				t.Errorf("role %v permission %v = %v, want %v", role, perm, got, allowed[i])
+			}
+		}
+	}
+}
+
+func TestOutranks(t *testing.T) {
+	cases := []struct {
+		role, target string
+		want         bool
+	}{
+		{RoleOwner, RoleAdmin, true},
+		{RoleOwner, RoleMember, true},
+		{RoleAdmin, RoleMember, true},
+		{RoleAdmin, RoleAdmin, false},
+		{RoleAdmin, RoleOwner, false},
+		{RoleMember, RoleMember, false},
+		{RoleOwner, RoleOwner, false},
+		{"unknown", RoleMember, false},
+		{RoleMember, "unknown", true},
+	}
+	for _, c := range cases {
+		if got := outranks(c.role, c.target); got != c.want {
+			t.Errorf("outranks(%v, %v) = %v, want %v", c.role, c.target, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"framework/api"
+	"framework/api/model"
+	"framework/logger"
+	"html"
+	"logic/dao"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+	// snippetRadius 命中位置前后保留的字符数
+	snippetRadius = 30
+
+	highlightOpen  = "<em>"
+	highlightClose = "</em>"
+)
+
+// SearchResult 命中的消息与高亮后的内容片段
+type SearchResult struct {
+	Message *dao.ChatMessage `json:"message"`
+	Snippet string           `json:"snippet"`
+}
+
+// SearchMessageResponse NextCursor 传回 Before 继续搜索更早的消息
+type SearchMessageResponse struct {
+	Results    []*SearchResult `json:"results"`
+	NextCursor string          `json:"nextCursor"`
+	HasMore    bool            `json:"hasMore"`
+}
+
+// accessibleRooms 用户可以拉取消息的房间: 好友的单聊房间与所在群组
+func (s *Server) accessibleRooms(uid string) ([]string, error) {
+	friends, err := model.GetFriendDatasByUID(uid)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := model.GetGroupDatasByUID(uid)
+	if err != nil {
+		return nil, err
+	}
+	roomIDs := make([]string, 0, len(friends)+len(groups))
+	for _, friend := range friends {
+		roomIDs = append(roomIDs, friend.RoomID)
+	}
+	for _, group := range groups {
+		roomIDs = append(roomIDs, group.Group.GroupID)
+	}
+	return roomIDs, nil
+}
+
+// SearchMessages 在用户可访问的房间内搜索, 指定 RoomID 时只搜索该房间
+func (s *Server) SearchMessages(sR *SearchMessageRequest) (*SearchMessageResponse, error) {
+	query := strings.TrimSpace(sR.Query)
+	if len(query) == 0 {
+		return nil, api.ErrorCodeToError(api.ErrorHttpParamInvalid)
+	}
+	roomIDs, err := s.accessibleRooms(sR.UID)
+	if err != nil {
+		logger.Error("Logic.SearchMessages get rooms err: %v", err)
+		return nil, err
+	}
+	if len(sR.RoomID) > 0 {
+		if !containsString(roomIDs, sR.RoomID) {
+			return nil, ErrNotRoomMember
+		}
+		roomIDs = []string{sR.RoomID}
+	}
+	limit := sR.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+	search := &dao.MessageSearch{
+		RoomIDs: roomIDs,
+		Query:   query,
+		From:    sR.From,
+		Type:    sR.Type,
+		Before:  sR.Before,
+		Limit:   limit,
+	}
+	if sR.Start > 0 {
+		search.Start = time.Unix(0, sR.Start*int64(time.Millisecond))
+	}
+	if sR.End > 0 {
+		search.End = time.Unix(0, sR.End*int64(time.Millisecond))
+	}
+	messages, hasMore, err := dao.SearchChatMessages(sR.UID, search)
+	if err != nil {
+		logger.Error("Logic.SearchMessages err: %v", err)
+		return nil, err
+	}
+	resp := &SearchMessageResponse{Results: make([]*SearchResult, 0, len(messages)), HasMore: hasMore}
+	for _, message := range messages {
+		resp.Results = append(resp.Results, &SearchResult{Message: message, Snippet: highlight(message.Content, query)})
+	}
+	if len(messages) > 0 {
+		resp.NextCursor = messages[len(messages)-1].MessageID
+	}
+	return resp, nil
+}
+
+// highlight 截取第一处命中前后 snippetRadius 个字符, 并用 <em> 标出片段内所有命中
+// 片段中的内容经过 HTML 转义, 只有 <em> 标签是标记
+func highlight(content, query string) string {
+	lower, lowerQuery := strings.ToLower(content), strings.ToLower(query)
+	// ToLower 可能改变个别字符的字节长度, 这时偏移无法对应回原文, 退化为不截取不高亮
+	if len(lower) != len(content) || len(lowerQuery) != len(query) {
+		return html.EscapeString(content)
+	}
+	first := strings.Index(lower, lowerQuery)
+	if first < 0 {
+		return html.EscapeString(content)
+	}
+	start, end := first, first+len(lowerQuery)
+	for i := 0; i < snippetRadius && start > 0; i++ {
+		_, size := utf8.DecodeLastRuneInString(content[:start])
+		start -= size
+	}
+	for i := 0; i < snippetRadius && end < len(content); i++ {
+		_, size := utf8.DecodeRuneInString(content[end:])
+		end += size
+	}
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("...")
+	}
+	for pos := start; pos < end; {
+		idx := strings.Index(lower[pos:end], lowerQuery)
+		if idx < 0 {
+			b.WriteString(html.EscapeString(content[pos:end]))
+			break
+		}
+		b.WriteString(html.EscapeString(content[pos : pos+idx]))
+		b.WriteString(highlightOpen)
+		b.WriteString(html.EscapeString(content[pos+idx : pos+idx+len(lowerQuery)]))
+		b.WriteString(highlightClose)
+		pos += idx + len(lowerQuery)
+	}
+	if end < len(content) {
+		b.WriteString("...")
+	}
+	return b.String()
+}
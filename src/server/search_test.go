@@ -0,0 +1,38 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+	cases := []struct {
+		name, content, query, want string
+	}{
+		{"no match", "a<b", "z", "a&lt;b"},
+		{"single match", "hello world", "world", "hello <em>world</em>"},
+		{"case insensitive keeps original case", "Hello HELLO", "hello", "<em>Hello</em> <em>HELLO</em>"},
+		{"escapes around match", "<b>x</b>", "x", "&lt;b&gt;<em>x</em>&lt;/b&gt;"},
+		{"escapes inside match", "a<&b", "<&", "a<em>&lt;&amp;</em>b"},
+		{"multibyte", "你好世界", "世界", "你好<em>世界</em>"},
+		{"kelvin sign in content", "300K", "k", "300K"},
+		{"kelvin sign in query", "k", "K", "k"},
+		{
+			"snippet cut on both sides",
+			strings.Repeat("a", 40) + "X" + strings.Repeat("b", 40), "x",
+			"..." + strings.Repeat("a", snippetRadius) + "<em>X</em>" + strings.Repeat("b", snippetRadius) + "...",
+		},
+		{
+			"snippet counts runes",
+			strings.Repeat("中", 35) + "X", "x",
+			"..." + strings.Repeat("中", snippetRadius) + "<em>X</em>",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := highlight(c.content, c.query); got != c.want {
+				t.Errorf("highlight(%q, %q) = %q, want %q", c.content, c.query, got, c.want)
+			}
+		})
+	}
+}
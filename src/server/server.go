@@ -1,30 +1,54 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
 	"framework/api"
-	"framework/api/model"
 	"framework/broker"
 	"framework/cfgargs"
 	"framework/logger"
 	"framework/net/http"
 	"github.com/gin-gonic/gin"
+	"logic/conf"
+	"logic/dao"
+	"logic/mq"
+	"sync/atomic"
+	"time"
 )
 
+var ErrServerShuttingDown = errors.New("logic server is shutting down")
+
 type Server struct {
 	cfg          *cfgargs.SrvConfig
 	logicBroker  broker.LogicBroker
 	httpSrv      *http.Server
 	httpClient   *http.Client
-	messageQueue chan *model.ChatMessage
+	logicCfg     *conf.LogicConfig
+	messageQueue mq.MessageQueue
+	roomLocks    *stripedLock
+	loadPusher   *loadPusher
+	gates        *gateClient
+	invoker      *invoker
+
+	// closing 非 0 表示已开始关闭
+	closing int32
+	// requests 处理中的请求, tasks 请求与消费者派生的后台推送, invokes 未完成的 broker 调用
+	requests     tracker
+	tasks        tracker
+	invokes      tracker
+	consumerDone chan struct{}
 }
 
 func NewServer() *Server {
 	return &Server{
-		messageQueue: make(chan *model.ChatMessage, 5000),
+		consumerDone: make(chan struct{}),
+		roomLocks:    newStripedLock(64),
 	}
 }
 
-func (s *Server) Init(cfg *cfgargs.SrvConfig) {
+func (s *Server) Init(cfg *cfgargs.SrvConfig, logicCfg *conf.LogicConfig) {
 	gin.DefaultWriter = logger.MultiWriter(logger.DefLogger().GetLogWriters()...)
 	if cfg.Gate.Mode == "http" {
 		s.logicBroker = broker.NewLogicBrokerHttp()
@@ -32,6 +56,24 @@ func (s *Server) Init(cfg *cfgargs.SrvConfig) {
 	}
 	s.logicBroker.Init(cfg)
 	s.cfg = cfg
+	s.logicCfg = logicCfg
+	messageQueue, err := mq.NewMessageQueue(&logicCfg.Queue)
+	if err != nil {
+		logger.Fatal("Logic.Init message queue err: %v", err)
+		return
+	}
+	s.messageQueue = messageQueue
+	if err = dao.EnsureIndexes(); err != nil {
+		logger.Fatal("Logic.Init ensure indexes err: %v", err)
+		return
+	}
+	if logicCfg.Auth.Enabled && len(logicCfg.Presence.GateSecret) == 0 {
+		logger.Warn("Logic.Init auth is enabled without presence.gateSecret, connect and disconnect will be rejected")
+	}
+	s.gates = newGateClient(logicCfg.Presence.Nodes, logicCfg.Presence.GateSecret, time.Duration(logicCfg.Presence.Timeout)*time.Millisecond)
+	s.invoker = newInvoker(s.logicBroker, s.gates, logicCfg.Invoke)
+	s.loadPusher = newLoadPusher(time.Duration(logicCfg.LoadPush.Delay)*time.Millisecond,
+		logicCfg.LoadPush.Workers, logicCfg.LoadPush.QueueSize, s.pushLoadDataNow)
 	s.httpClient = http.NewClient()
 	s.httpSrv = http.NewServer()
 	s.httpSrv.Init(cfg)
@@ -41,7 +83,8 @@ func (s *Server) Init(cfg *cfgargs.SrvConfig) {
 
 func (s *Server) Run() {
 	go func() {
-		s.Consume(s.ConsumeMessage)
+		defer close(s.consumerDone)
+		s.Consume(s.ConsumeMessages)
 	}()
 	go s.logicBroker.Listen()
 	//go s.httpSrv.Run()
@@ -51,38 +94,180 @@ func (s *Server) MountRoute() {
 	path := ""
 	routers := []*http.Route{
 		// TODO: Mount routes
-		http.NewRoute(api.HTTPMethodPost, api.EventChat, s.Chat),
-		http.NewRoute(api.HTTPMethodPost, api.EventAuth, s.Auth),
-		http.NewRoute(api.HTTPMethodPost, api.EventLoad, s.Load),
-		http.NewRoute(api.HTTPMethodPost, api.EventAddFriend, s.AddFriend),
-		http.NewRoute(api.HTTPMethodPost, api.EventDeleteFriend, s.DeleteFriend),
-		http.NewRoute(api.HTTPMethodPost, api.EventCreateGroup, s.CreateGroup),
-		http.NewRoute(api.HTTPMethodPost, api.EventJoinGroup, s.JoinGroup),
-		http.NewRoute(api.HTTPMethodPost, api.EventLeaveGroup, s.LeaveGroup),
-		http.NewRoute(api.HTTPMethodPost, api.EventGetUserInfo, s.GetUserInfo),
-		http.NewRoute(api.HTTPMethodPost, api.EventFindUser, s.FindUser),
-		http.NewRoute(api.HTTPMethodPost, api.EventFindGroup, s.FindGroup),
-		http.NewRoute(api.HTTPMethodPost, api.EventInviteFriend, s.InviteFriend),
-		http.NewRoute(api.HTTPMethodPost, api.EventPullMessage, s.PullMessage),
-		http.NewRoute(api.HTTPMethodPost, api.EventUpdateUser, s.UpdateUser),
-		http.NewRoute(api.HTTPMethodPost, api.EventUpdateGroup, s.UpdateGroup),
+		s.route(api.EventChat, s.Chat, s.chatRule),
+		s.route(api.EventAuth, s.Auth, nil),
+		s.route(EventLogout, s.Logout, s.authenticated),
+		s.route(api.EventLoad, s.Load, s.loadRule),
+		s.route(api.EventAddFriend, s.AddFriend, s.friendRule),
+		s.route(api.EventDeleteFriend, s.DeleteFriend, s.friendRule),
+		s.route(EventAcceptFriend, s.AcceptFriend, s.friendActionRule),
+		s.route(EventRejectFriend, s.RejectFriend, s.friendActionRule),
+		s.route(EventCancelFriend, s.CancelFriend, s.friendActionRule),
+		s.route(EventBlockUser, s.Block, s.blockRule),
+		s.route(EventUnblockUser, s.Unblock, s.blockRule),
+		s.route(EventGetBlockList, s.BlockList, s.blockRule),
+		s.route(EventSync, s.Sync, s.syncRule),
+		s.route(EventMarkRead, s.MarkRead, s.markReadRule),
+		s.route(EventAck, s.Ack, s.ackRule),
+		s.gateRoute(EventConnect, s.Connect),
+		s.gateRoute(EventDisconnect, s.Disconnect),
+		s.route(EventSetPresence, s.SetPresence, s.presenceRule),
+		s.route(EventTyping, s.Typing, s.chatRule),
+		s.route(EventRecallMessage, s.Recall, s.messageRule),
+		s.route(EventEditMessage, s.Edit, s.messageRule),
+		s.route(EventDeleteMessage, s.DeleteMessage, s.messageRule),
+		s.route(EventGetMessageEdits, s.MessageEdits, s.messageRule),
+		s.route(EventPullThread, s.PullThread, s.pullThreadRule),
+		s.route(EventPullMessageCursor, s.PullMessageCursor, s.cursorPullRule),
+		s.route(EventSearchMessage, s.SearchMessage, s.searchRule),
+		s.route(EventUpdateGroupSetting, s.UpdateGroupSetting, s.groupSettingRule),
+		s.route(EventApproveJoin, s.ApproveJoin, s.joinRequestRule),
+		s.route(EventRejectJoin, s.RejectJoin, s.joinRequestRule),
+		s.route(EventGetJoinRequests, s.JoinRequests, s.groupSelfRule),
+		s.adminRoute(EventGetDeadLetters, s.DeadLetters),
+		s.adminRoute(EventReplayDeadLetters, s.ReplayDeadLetters),
+		s.adminRoute(EventGetMetrics, gin.WrapH(expvar.Handler())),
+		s.route(api.EventCreateGroup, s.CreateGroup, s.groupSelfRule),
+		s.route(api.EventJoinGroup, s.JoinGroup, s.groupSelfRule),
+		s.route(api.EventLeaveGroup, s.LeaveGroup, s.groupSelfRule),
+		s.route(api.EventGetUserInfo, s.GetUserInfo, s.authenticated),
+		s.route(api.EventFindUser, s.FindUser, s.authenticated),
+		s.route(api.EventFindGroup, s.FindGroup, s.authenticated),
+		s.route(api.EventInviteFriend, s.InviteFriend, s.inviteRule),
+		s.route(api.EventPullMessage, s.PullMessage, s.pullRule),
+		s.route(api.EventUpdateUser, s.UpdateUser, s.updateUserRule),
+		s.route(api.EventUpdateGroup, s.UpdateGroup, s.updateGroupRule),
+		s.route(EventPromoteAdmin, s.PromoteAdmin, s.groupMemberRule),
+		s.route(EventDemoteAdmin, s.DemoteAdmin, s.groupMemberRule),
+		s.route(EventTransferOwner, s.TransferOwner, s.groupMemberRule),
+		s.route(EventKickMember, s.KickMember, s.groupMemberRule),
+		s.route(EventBanMember, s.BanMember, s.groupMemberRule),
+		s.route(EventUnbanMember, s.UnbanMember, s.groupMemberRule),
 	}
 	node := http.NewNodeRoute(path, routers...)
 	s.logicBroker.(*broker.LogicBrokerHttp).AddNodeRoute(node)
 	s.httpSrv.AddNodeRoute(node)
 }
 
-func (s *Server) Produce(message *model.ChatMessage) {
+// Shutdown 依次停止接收请求, 关闭消息队列, 等待消费者排空, 等待后台推送, 等待未完成的 broker 调用
+func (s *Server) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&s.closing, 0, 1) {
+		return nil
+	}
+	logger.Info("Logic.Shutdown: stop accepting routes")
+	if err := s.requests.closeAndWait(ctx); err != nil {
+		return fmt.Errorf("wait in-flight requests: %w", err)
+	}
+
+	logger.Info("Logic.Shutdown: close message queue")
+	if err := s.messageQueue.Close(); err != nil {
+		logger.Error("Logic.Shutdown close message queue err: %v", err)
+	}
+
+	logger.Info("Logic.Shutdown: drain message queue")
+	select {
+	case <-s.consumerDone:
+	case <-ctx.Done():
+		return fmt.Errorf("drain message queue: %w", ctx.Err())
+	}
+
+	logger.Info("Logic.Shutdown: wait background pushes")
+	if err := s.tasks.closeAndWait(ctx); err != nil {
+		return fmt.Errorf("wait background pushes: %w", err)
+	}
+
+	// 后台推送可能再触发 load 推送, 在它们结束后再停止
+	logger.Info("Logic.Shutdown: stop load data pusher")
+	if err := s.loadPusher.stop(ctx); err != nil {
+		return fmt.Errorf("stop load data pusher: %w", err)
+	}
+
+	logger.Info("Logic.Shutdown: wait broker invokes")
+	s.invoker.stop()
+	if err := s.invokes.closeAndWait(ctx); err != nil {
+		return fmt.Errorf("wait broker invokes: %w", err)
+	}
+	logger.Info("Logic.Shutdown: done")
+	return nil
+}
+
+// route 挂载 POST 路由, rule 为该事件的鉴权规则
+func (s *Server) route(event string, handler gin.HandlerFunc, rule authRule) *http.Route {
+	return http.NewRoute(api.HTTPMethodPost, event, s.accept(s.authorize(rule, handler)))
+}
+
+// adminRoute 挂载管理接口, 无论是否开启鉴权都只允许 auth.admins 调用
+func (s *Server) adminRoute(event string, handler gin.HandlerFunc) *http.Route {
+	return http.NewRoute(api.HTTPMethodPost, event, s.accept(s.enforce(s.adminRule, handler)))
+}
+
+// gateRoute 挂载由 gate 调用的接口, 开启鉴权时校验 X-Gate-Secret
+func (s *Server) gateRoute(event string, handler gin.HandlerFunc) *http.Route {
+	return http.NewRoute(api.HTTPMethodPost, event, s.accept(s.authorizeGate(handler)))
+}
+
+func (s *Server) Produce(message *dao.ChatMessage) error {
 	// MQ　producer
 	logger.Info("Logic.Produce: produce new message: [%+v]", *message)
-	s.messageQueue <- message
+	if err := s.messageQueue.Produce(message); err != nil {
+		logger.Error("Logic.Produce err: %v, message: [%+v]", err, *message)
+		return err
+	}
+	return nil
 }
 
-func (s *Server) Consume(consumerFunc func(message *model.ChatMessage)) {
-	for message := range s.messageQueue {
-		// MQ consumer
-		logger.Info("Logic.Consume: consume message: [%+v]", *message)
-		//s.PushChatMessage(message)
-		consumerFunc(message)
+// Consume 按数量和时间攒批后交给 consumerFunc, consumerFunc 返回与批次一一对应的错误, 成功的消息才会 Ack
+func (s *Server) Consume(consumerFunc func(messages []*dao.ChatMessage) []error) {
+	batchSize := s.logicCfg.Consumer.BatchSize
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	flushInterval := time.Duration(s.logicCfg.Consumer.FlushInterval) * time.Millisecond
+	if flushInterval <= 0 {
+		flushInterval = time.Second
+	}
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*mq.Message, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		payloads := make([]*dao.ChatMessage, 0, len(batch))
+		for _, message := range batch {
+			payloads = append(payloads, message.Payload)
+		}
+		logger.Info("Logic.Consume: consume %v messages", len(batch))
+		errs := consumerFunc(payloads)
+		for i, message := range batch {
+			if i < len(errs) && errs[i] != nil {
+				// 不 Ack, 重启后重放
+				continue
+			}
+			if err := s.messageQueue.Ack(message.ID); err != nil {
+				logger.Error("Logic.Consume ack %v err: %v", message.ID, err)
+			}
+		}
+		batch = batch[:0]
+	}
+
+	messages := s.messageQueue.Messages()
+	for {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				flush()
+				return
+			}
+			// MQ consumer
+			logger.Debug("Logic.Consume: receive message: [%v][%+v]", message.ID, *message.Payload)
+			batch = append(batch, message)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
 	}
 }
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"logic/conf"
+	"logic/dao"
+	"logic/mq"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventLog 记录关闭过程中各阶段发生的顺序
+type eventLog struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *eventLog) add(event string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
+
+func (l *eventLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.events...)
+}
+
+// newShutdownServer 只初始化 Shutdown 用到的部分, consume 作为消费函数运行
+func newShutdownServer(consume func(messages []*dao.ChatMessage) []error, push func(uid string, flush bool)) *Server {
+	s := NewServer()
+	s.logicCfg = conf.DefaultLogicConfig()
+	s.logicCfg.Consumer.BatchSize = 1
+	s.messageQueue = mq.NewMemoryQueue(0)
+	s.loadPusher = newLoadPusher(time.Millisecond, 1, 16, push)
+	s.invoker = newInvoker(nil, nil, s.logicCfg.Invoke)
+	go func() {
+		defer close(s.consumerDone)
+		s.Consume(consume)
+	}()
+	return s
+}
+
+func chatMessageWithID(id string) *dao.ChatMessage {
+	m := &dao.ChatMessage{}
+	m.MessageID = id
+	return m
+}
+
+func TestShutdownOrder(t *testing.T) {
+	log := &eventLog{}
+	var s *Server
+	s = newShutdownServer(func(messages []*dao.ChatMessage) []error {
+		for _, m := range messages {
+			time.Sleep(5 * time.Millisecond)
+			log.add("consume " + m.MessageID)
+		}
+		// 消费者派生的后台推送在队列排空后仍要等待, 它触发的 load 推送在它结束前入队
+		s.spawn(func() {
+			time.Sleep(10 * time.Millisecond)
+			log.add("task")
+			s.loadPusher.schedule("u1", false)
+			time.Sleep(20 * time.Millisecond)
+		})
+		return nil
+	}, func(uid string, flush bool) {
+		log.add("load " + uid)
+	})
+
+	// 关闭开始时仍在处理的请求还能写入队列
+	if !s.requests.add() {
+		t.Fatal("request rejected before shutdown")
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(context.Background()) }()
+	time.Sleep(20 * time.Millisecond)
+	for _, id := range []string{"m1", "m2"} {
+		if err := s.Produce(chatMessageWithID(id)); err != nil {
+			t.Fatalf("produce during in-flight request err: %v", err)
+		}
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned %v before the in-flight request finished", err)
+	default:
+	}
+	s.requests.done()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// 两条消息各派生一次任务, 两次 load 推送可能合并, 只校验每个阶段都在前一阶段之后完成
+	got := log.get()
+	last := map[string]int{}
+	for i, event := range got {
+		last[event] = i + 1
+	}
+	if last["consume m1"] == 0 || last["consume m2"] == 0 || last["task"] == 0 || last["load u1"] == 0 {
+		t.Fatalf("Shutdown returned before every stage finished: %v", got)
+	}
+	if last["consume m2"] > last["task"] || last["task"] > last["load u1"] {
+		t.Fatalf("events out of order: %v", got)
+	}
+
+	if err := s.Produce(chatMessageWithID("m3")); !errors.Is(err, mq.ErrQueueClosed) {
+		t.Fatalf("produce after shutdown err = %v, want ErrQueueClosed", err)
+	}
+}
+
+func TestShutdownRejectsNewWork(t *testing.T) {
+	s := newShutdownServer(func(messages []*dao.ChatMessage) []error { return nil }, func(string, bool) {})
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// 重复关闭直接返回
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	called := false
+	s.accept(func(*gin.Context) { called = true })(c)
+	if called || !c.IsAborted() {
+		t.Fatalf("request accepted after shutdown")
+	}
+
+	// 关闭后派生的任务在当前协程执行完
+	ran := false
+	s.spawn(func() { ran = true })
+	if !ran {
+		t.Fatal("spawn after shutdown did not run the task inline")
+	}
+
+	var invokeErr error
+	s.InvokeTargetWithCallback("event", nil, func(err error) { invokeErr = err }, "u1")
+	if invokeErr != ErrServerShuttingDown {
+		t.Fatalf("invoke after shutdown err = %v, want ErrServerShuttingDown", invokeErr)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	s := newShutdownServer(func(messages []*dao.ChatMessage) []error { return nil }, func(string, bool) {})
+	if !s.requests.add() {
+		t.Fatal("request rejected before shutdown")
+	}
+	defer s.requests.done()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown err = %v, want deadline exceeded", err)
+	}
+}
+
+func TestConsumeAcksOnlySucceeded(t *testing.T) {
+	dir := t.TempDir()
+	queue, err := mq.NewWALQueue(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer()
+	s.logicCfg = conf.DefaultLogicConfig()
+	s.logicCfg.Consumer.BatchSize = 3
+	s.messageQueue = queue
+	for _, id := range []string{"m1", "m2", "m3"} {
+		if err = s.Produce(chatMessageWithID(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var batches [][]string
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		queue.Close()
+	}()
+	s.Consume(func(messages []*dao.ChatMessage) []error {
+		ids := make([]string, 0, len(messages))
+		errs := make([]error, len(messages))
+		for i, m := range messages {
+			ids = append(ids, m.MessageID)
+			if m.MessageID == "m2" {
+				errs[i] = errors.New("insert failed")
+			}
+		}
+		batches = append(batches, ids)
+		return errs
+	})
+	if !reflect.DeepEqual(batches, [][]string{{"m1", "m2", "m3"}}) {
+		t.Fatalf("batches = %v, want one batch of three", batches)
+	}
+
+	// 未 Ack 的消息在重启后重放
+	replayed, err := mq.NewWALQueue(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayed.Close()
+	select {
+	case m := <-replayed.Messages():
+		if m.Payload.MessageID != "m2" {
+			t.Fatalf("replayed %v, want m2", m.Payload.MessageID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("failed message was not replayed")
+	}
+}
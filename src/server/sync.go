@@ -0,0 +1,176 @@
+package server
+
+import (
+	"framework/api/model"
+	"framework/logger"
+	"logic/dao"
+	"time"
+)
+
+// 增量变更类型, 作为推送事件名和 SyncChange.Type
+const (
+	ChangeFriendAdded   = "friendAdded"
+	ChangeFriendDeleted = "friendDeleted"
+	ChangeGroupAdded    = "groupAdded"
+	ChangeGroupRemoved  = "groupRemoved"
+	ChangeGroupUpdated  = "groupUpdated"
+	ChangeMemberJoined  = "memberJoined"
+	ChangeMemberLeft    = "memberLeft"
+	ChangeUserUpdated   = "userUpdated"
+)
+
+// FriendDeleted 好友被删除的变更数据
+type FriendDeleted struct {
+	UID string `json:"uid"`
+}
+
+// GroupRemoved 自己离开或被移出群组的变更数据
+type GroupRemoved struct {
+	GroupID string `json:"groupID"`
+}
+
+// MemberJoined 群组新成员的变更数据
+type MemberJoined struct {
+	GroupID string        `json:"groupID"`
+	Members []*PublicUser `json:"members"`
+}
+
+// MemberLeft 群组成员离开的变更数据
+type MemberLeft struct {
+	GroupID string `json:"groupID"`
+	UID     string `json:"uid"`
+}
+
+// SyncResponse 增量同步结果, 版本差距过大或版本无效时 Full 为 true 并附带全量数据
+type SyncResponse struct {
+	Version  int64             `json:"version"`
+	Changes  []*dao.SyncChange `json:"changes"`
+	Full     bool              `json:"full"`
+	LoadData interface{}       `json:"loadData,omitempty"`
+}
+
+// PushChange 为每个用户记录一条增量变更并推送, 事件名为变更类型
+func (s *Server) PushChange(changeType string, data interface{}, uids ...string) {
+	if len(uids) == 0 {
+		return
+	}
+	cfg := s.logicCfg.Sync
+	changes, err := dao.AppendSyncChanges(uids, changeType, data, cfg.MaxChanges, time.Duration(cfg.TTL)*time.Second)
+	if err != nil {
+		// 记录失败时客户端版本无法衔接, 退回全量推送
+		logger.Error("Logic.PushChange %v append change err: %v, fallback to load data", changeType, err)
+		for _, uid := range uids {
+			s.PushLoadData(uid)
+		}
+		return
+	}
+	for uid, change := range changes {
+		s.InvokeTarget(changeType, change, uid)
+		if cfg.LegacyPush {
+			s.PushLoadData(uid)
+		}
+	}
+}
+
+// GetChangesSince 返回 version 之后的变更, 无法衔接时返回全量数据
+func (s *Server) GetChangesSince(uid string, version int64) (*SyncResponse, error) {
+	resp, err := s.changesSince(uid, version)
+	if err != nil || !resp.Full {
+		return resp, err
+	}
+	if resp.LoadData, err = s.GetLoadData(uid); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// changesSince 只读取变更日志, 无法衔接时返回 Full 为 true 且不带全量数据的结果
+func (s *Server) changesSince(uid string, version int64) (*SyncResponse, error) {
+	current, err := dao.GetSyncVersion(uid)
+	if err != nil {
+		return nil, err
+	}
+	if version == current {
+		return &SyncResponse{Version: current, Changes: []*dao.SyncChange{}}, nil
+	}
+	if version >= 0 && version < current && current-version <= int64(s.logicCfg.Sync.MaxChanges) {
+		changes, err := dao.GetSyncChangesSince(uid, version)
+		if err != nil {
+			return nil, err
+		}
+		if len(changes) > 0 && changes[0].Version == version+1 {
+			return &SyncResponse{Version: changes[len(changes)-1].Version, Changes: changes}, nil
+		}
+	}
+	return &SyncResponse{Version: current, Changes: []*dao.SyncChange{}, Full: true}, nil
+}
+
+// PushFriendAdded 双方各自收到对方的好友数据
+func (s *Server) PushFriendAdded(uid, friendID string) {
+	for _, pair := range [][2]string{{uid, friendID}, {friendID, uid}} {
+		friendData, err := model.GetFriendDataByIDs(pair[0], pair[1])
+		if err != nil {
+			logger.Error("Logic.PushFriendAdded get friend data err: %v", err)
+			continue
+		}
+		s.PushChange(ChangeFriendAdded, friendData, pair[0])
+	}
+}
+
+func (s *Server) PushFriendDeleted(uid, friendID string) {
+	s.PushChange(ChangeFriendDeleted, &FriendDeleted{UID: friendID}, uid)
+	s.PushChange(ChangeFriendDeleted, &FriendDeleted{UID: uid}, friendID)
+}
+
+// PushMembersJoined 新成员收到完整群组数据, 原成员收到新成员信息
+func (s *Server) PushMembersJoined(groupID string, uids ...string) {
+	groupData, err := model.GetGroupDataByGroupID(groupID)
+	if err != nil {
+		logger.Error("Logic.PushMembersJoined get group data err: %v", err)
+		return
+	}
+	joined := &MemberJoined{GroupID: groupID, Members: make([]*PublicUser, 0, len(uids))}
+	existing := make([]string, 0, len(groupData.Members))
+	for _, member := range groupData.Members {
+		if containsString(uids, member.UID) {
+			joined.Members = append(joined.Members, NewPublicUser(member))
+		} else {
+			existing = append(existing, member.UID)
+		}
+	}
+	s.PushChange(ChangeGroupAdded, groupData, uids...)
+	s.PushChange(ChangeMemberJoined, joined, existing...)
+}
+
+// PushMemberLeft 剩余成员收到成员离开, 离开的用户收到群组移除
+func (s *Server) PushMemberLeft(groupID, uid string) {
+	uids, err := model.GetUserIDsByGroupID(groupID)
+	if err != nil {
+		logger.Error("Logic.PushMemberLeft get group users err: %v", err)
+		return
+	}
+	s.PushChange(ChangeMemberLeft, &MemberLeft{GroupID: groupID, UID: uid}, uids...)
+	s.PushChange(ChangeGroupRemoved, &GroupRemoved{GroupID: groupID}, uid)
+}
+
+func (s *Server) PushGroupUpdated(group *model.Group) {
+	uids, err := model.GetUserIDsByGroupID(group.GroupID)
+	if err != nil {
+		logger.Error("Logic.PushGroupUpdated get group users err: %v", err)
+		return
+	}
+	s.PushChange(ChangeGroupUpdated, group, uids...)
+}
+
+// PushUserUpdated 推送给用户自己以及好友和同群成员, 只推送公开资料
+func (s *Server) PushUserUpdated(user *model.User) {
+	uids, err := model.GetAssociatedUIDsByUID(user.UID)
+	if err != nil {
+		logger.Error("Logic.PushUserUpdated get associated users err: %v", err)
+		return
+	}
+	if !containsString(uids, user.UID) {
+		uids = append(uids, user.UID)
+	}
+	s.PushChange(ChangeUserUpdated, NewPublicUser(user), uids...)
+}
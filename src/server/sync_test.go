@@ -0,0 +1,60 @@
+package server
+
+import (
+	"logic/conf"
+	"logic/dao"
+	"testing"
+	"time"
+)
+
+func TestChangesSince(t *testing.T) {
+	mr := useMiniredis(t)
+	s := NewServer()
+	s.logicCfg = conf.DefaultLogicConfig()
+	s.logicCfg.Sync.MaxChanges = 3
+	for i := 0; i < 5; i++ {
+		if _, err := dao.AppendSyncChanges([]string{"u1"}, ChangeFriendAdded, nil, 3, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name     string
+		version  int64
+		full     bool
+		versions []int64
+	}{
+		{"up to date", 5, false, []int64{}},
+		{"one behind", 4, false, []int64{5}},
+		{"oldest kept", 2, false, []int64{3, 4, 5}},
+		{"trimmed", 1, true, []int64{}},
+		{"ahead of server", 6, true, []int64{}},
+		{"negative", -1, true, []int64{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resp, err := s.changesSince("u1", c.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Full != c.full || resp.Version != 5 || len(resp.Changes) != len(c.versions) {
+				t.Fatalf("resp = %+v, want full %v and versions %v", resp, c.full, c.versions)
+			}
+			for i, change := range resp.Changes {
+				if change.Version != c.versions[i] {
+					t.Fatalf("change %v version = %v, want %v", i, change.Version, c.versions[i])
+				}
+			}
+		})
+	}
+
+	// 日志过期后旧版本无法衔接, 版本号保持不变
+	mr.FastForward(time.Hour)
+	resp, err := s.changesSince("u1", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Full || resp.Version != 5 {
+		t.Fatalf("after log expired resp = %+v, want full at version 5", resp)
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"sync"
+)
+
+// tracker 可关闭的 WaitGroup
+// add 与 close 互斥, close 之后不再接受新的任务, 保证 wait 期间计数不会从 0 重新增加
+type tracker struct {
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// add 登记一个任务, 已关闭时返回 false
+func (t *tracker) add() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		return false
+	}
+	t.wg.Add(1)
+	return true
+}
+
+func (t *tracker) done() {
+	t.wg.Done()
+}
+
+// closeAndWait 拒绝新的任务并等待已登记的任务结束
+func (t *tracker) closeAndWait(ctx context.Context) error {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// spawn 在调用方返回前登记后台任务, 使 Shutdown 能等到它结束
+// 任务登记关闭后 (Shutdown 已在等待后台任务) 直接在当前协程执行
+func (s *Server) spawn(task func()) {
+	if !s.tasks.add() {
+		task()
+		return
+	}
+	go func() {
+		defer s.tasks.done()
+		task()
+	}()
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"framework/api"
+	"framework/api/model"
+	"logic/dao"
+)
+
+// ChatRequest 在 api.ChatRequest 基础上增加客户端消息 ID, 用于重试去重
+type ChatRequest struct {
+	api.ChatRequest
+	ClientMsgID string `json:"clientMsgID"`
+	// ReplyTo 回复的消息 ID, ThreadRoot 所属话题首条消息的 ID, 均须在同一房间
+	ReplyTo    string `json:"replyTo"`
+	ThreadRoot string `json:"threadRoot"`
+	// Mentions 被提及的群成员, MentionAll 提及全体成员, 仅用于群聊
+	Mentions   []string `json:"mentions"`
+	MentionAll bool     `json:"mentionAll"`
+}
+
+// ChatResponse Chat 成功后返回服务端分配的消息 ID 与房间序号, 客户端据此去重和排序
+// Duplicate 为 true 表示命中重试去重, Message 为首次发送的消息
+type ChatResponse struct {
+	MessageID   string           `json:"messageID"`
+	Seq         int64            `json:"seq"`
+	ClientMsgID string           `json:"clientMsgID,omitempty"`
+	Duplicate   bool             `json:"duplicate"`
+	Message     *dao.ChatMessage `json:"message,omitempty"`
+}
+
+// PublicUser 推送给其他用户的资料, 只包含公开字段
+type PublicUser struct {
+	UID     string `json:"uid"`
+	Account string `json:"account"`
+	Avatar  string `json:"avatar"`
+}
+
+func NewPublicUser(user *model.User) *PublicUser {
+	return &PublicUser{UID: user.UID, Account: user.Account, Avatar: user.Avatar}
+}
+
+// UpdateGroupRequest 在 api.UpdateGroupRequest 基础上增加操作者
+// UID 为空时取鉴权后的调用者, 兼容不携带 uid 的旧客户端
+type UpdateGroupRequest struct {
+	api.UpdateGroupRequest
+	UID string `json:"uid"`
+}
+
+// InviteRequest 在 api.InviteRequest 基础上增加操作者, UID 规则同 UpdateGroupRequest
+type InviteRequest struct {
+	api.InviteRequest
+	UID string `json:"uid"`
+}
+
+// GroupMemberRequest 群成员管理请求, UID 为操作者, Target 为被操作的成员
+type GroupMemberRequest struct {
+	UID     string `json:"uid"`
+	GroupID string `json:"groupID"`
+	Target  string `json:"target"`
+}
+
+const (
+	RemoveReasonKick = "kick"
+	RemoveReasonBan  = "ban"
+)
+
+// GroupMemberRemoved 成员被移出群组的推送
+type GroupMemberRemoved struct {
+	GroupID  string `json:"groupID"`
+	UID      string `json:"uid"`
+	Operator string `json:"operator"`
+	Reason   string `json:"reason"`
+}
+
+// AddFriendRequest 发送好友申请, FriendA 为申请方, FriendB 为接收方
+type AddFriendRequest struct {
+	api.FriendRequest
+	Greeting string `json:"greeting"`
+}
+
+// AddFriendResponse 对方已有待处理的反向申请时直接成为好友, Friend 非空
+type AddFriendResponse struct {
+	Request *dao.FriendRequest `json:"request"`
+	Friend  *model.FriendData  `json:"friend,omitempty"`
+}
+
+// FriendRequestAction 处理好友申请, UID 为操作者
+type FriendRequestAction struct {
+	UID       string `json:"uid"`
+	RequestID string `json:"requestID"`
+}
+
+// BlockRequest UID 屏蔽/取消屏蔽 Target
+type BlockRequest struct {
+	UID    string `json:"uid"`
+	Target string `json:"target"`
+}
+
+// FindRequest 在 api.FindRequest 基础上增加搜索者, 用于过滤屏蔽关系与搜索者自己
+// Limit 与 Cursor 都为空时按旧接口返回全部结果的数组
+type FindRequest struct {
+	api.FindRequest
+	UID            string `json:"uid"`
+	Limit          int    `json:"limit"`
+	Cursor         string `json:"cursor"`
+	ExcludeFriends bool   `json:"excludeFriends"`
+}
+
+func (f *FindRequest) paged() bool {
+	return f.Limit > 0 || len(f.Cursor) > 0
+}
+
+// GroupSettingRequest 只更新非空的字段, JoinPolicy 为 open|approval|inviteOnly
+type GroupSettingRequest struct {
+	UID        string  `json:"uid"`
+	GroupID    string  `json:"groupID"`
+	Private    *bool   `json:"private"`
+	JoinPolicy *string `json:"joinPolicy"`
+}
+
+// JoinRequestAction 审批入群申请
+type JoinRequestAction struct {
+	UID       string `json:"uid"`
+	RequestID string `json:"requestID"`
+}
+
+// SyncRequest 拉取 Version 之后的增量变更
+type SyncRequest struct {
+	UID     string `json:"uid"`
+	Version int64  `json:"version"`
+}
+
+// FriendData load 数据中的好友, 在 model.FriendData 基础上附加未读数
+type FriendData struct {
+	*model.FriendData
+	Unread   int64 `json:"unread"`
+	Online   bool  `json:"online"`
+	LastSeen int64 `json:"lastSeen"`
+}
+
+// GroupData load 数据中的群组, 在 model.GroupData 基础上附加未读数与未读的提及数
+type GroupData struct {
+	*model.GroupData
+	Unread   int64 `json:"unread"`
+	Mentions int64 `json:"mentions"`
+}
+
+// MarkReadRequest Seq 为 0 时标记房间内全部已读
+type MarkReadRequest struct {
+	UID    string `json:"uid"`
+	RoomID string `json:"roomID"`
+	Seq    int64  `json:"seq"`
+}
+
+// ConnectionRequest gate 上建立或断开的连接所属用户, gate 节点名在 GateHeader 中
+// ConnID 为 gate 内唯一的连接 ID, 携带时重复的 connect 只登记一次
+type ConnectionRequest struct {
+	UID    string `json:"uid"`
+	ConnID string `json:"connID"`
+}
+
+// PresenceRequest Hidden 为 true 时好友看到的始终是离线
+type PresenceRequest struct {
+	UID    string `json:"uid"`
+	Hidden bool   `json:"hidden"`
+}
+
+// TypingRequest 与 ChatRequest 的 from/to 一致, Typing 为 false 表示停止输入
+type TypingRequest struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Typing bool   `json:"typing"`
+}
+
+// TypingResponse Relayed 为 false 表示被限流或房间内没有其他成员
+type TypingResponse struct {
+	Relayed bool `json:"relayed"`
+}
+
+// MessageRequest 对单条消息的操作
+type MessageRequest struct {
+	UID       string `json:"uid"`
+	MessageID string `json:"messageID"`
+}
+
+// EditMessageRequest Content 为新内容
+type EditMessageRequest struct {
+	UID       string `json:"uid"`
+	MessageID string `json:"messageID"`
+	Content   string `json:"content"`
+}
+
+// PullThreadRequest 分页方式与 PullMessage 相同
+type PullThreadRequest struct {
+	UID        string `json:"uid"`
+	ThreadRoot string `json:"threadRoot"`
+	Current    int64  `json:"current"`
+	PageSize   int64  `json:"pageSize"`
+}
+
+// CursorPullRequest FriendID 与 GroupID 同 PullMessage; Cursor 为上一页返回的 nextCursor
+// 首页可用 MessageID 或毫秒时间戳 Timestamp 定位, Direction 为 before|after
+type CursorPullRequest struct {
+	UID       string `json:"uid"`
+	FriendID  string `json:"friendID"`
+	GroupID   string `json:"groupID"`
+	Cursor    string `json:"cursor"`
+	MessageID string `json:"messageID"`
+	Timestamp int64  `json:"timestamp"`
+	Direction string `json:"direction"`
+	Limit     int64  `json:"limit"`
+}
+
+// SearchMessageRequest RoomID 为空时搜索所有好友与群组, Start/End 为毫秒时间戳
+// Before 为上一页返回的 nextCursor
+type SearchMessageRequest struct {
+	UID    string `json:"uid"`
+	Query  string `json:"query"`
+	RoomID string `json:"roomID"`
+	From   string `json:"from"`
+	Type   string `json:"type"`
+	Start  int64  `json:"start"`
+	End    int64  `json:"end"`
+	Before string `json:"before"`
+	Limit  int64  `json:"limit"`
+}
+
+// AckRequest 客户端确认收到的消息
+type AckRequest struct {
+	UID        string   `json:"uid"`
+	MessageIDs []string `json:"messageIDs"`
+}
+
+// DeadLettersRequest 查询死信, Event 为空时不过滤
+type DeadLettersRequest struct {
+	Event string `json:"event"`
+	Limit int64  `json:"limit"`
+}
+
+// ReplayDeadLettersRequest 重放指定死信
+type ReplayDeadLettersRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// ReplayResult 重放结果, 成功的死信会被删除
+type ReplayResult struct {
+	Replayed []string          `json:"replayed"`
+	Failed   map[string]string `json:"failed"`
+}
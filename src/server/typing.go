@@ -0,0 +1,53 @@
+package server
+
+import (
+	"framework/logger"
+	"logic/dao"
+	"time"
+)
+
+// TypingIndicator 转发给房间其他成员的输入状态, 客户端在 Expire 毫秒内未收到新的状态时自动清除
+type TypingIndicator struct {
+	RoomID string `json:"roomID"`
+	UID    string `json:"uid"`
+	Typing bool   `json:"typing"`
+	Expire int64  `json:"expire"`
+}
+
+// RelayTyping 不落库, 按发送者和房间限流, 返回本次是否已转发
+// 停止输入只在之前转发过的开始输入仍在展示时转发一次
+func (s *Server) RelayTyping(uid, roomID string, typing bool) (bool, error) {
+	cfg := s.logicCfg.Typing
+	var (
+		ok  bool
+		err error
+	)
+	if typing {
+		ok, err = dao.ClaimTyping(uid, roomID, time.Duration(cfg.Interval)*time.Millisecond, time.Duration(cfg.Expire)*time.Millisecond)
+	} else {
+		ok, err = dao.ReleaseTyping(uid, roomID)
+	}
+	if err != nil {
+		logger.Error("Logic.RelayTyping typing: %v err: %v", typing, err)
+		return false, err
+	}
+	if !ok {
+		return false, nil
+	}
+	targets, err := s.ChatTargets(uid, roomID)
+	if err != nil {
+		return false, err
+	}
+	others := make([]string, 0, len(targets))
+	for _, target := range targets {
+		if target != uid {
+			others = append(others, target)
+		}
+	}
+	if len(others) == 0 {
+		return false, nil
+	}
+	indicator := &TypingIndicator{RoomID: roomID, UID: uid, Typing: typing, Expire: int64(cfg.Expire)}
+	s.InvokeTarget(EventTyping, indicator, others...)
+	return true, nil
+}